@@ -0,0 +1,434 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var attributeTypes = []string{models.AttrNumber, models.AttrInteger, models.AttrString, models.AttrEnum, models.AttrBool}
+
+// 属性键会拼进 jsonb 查询和导出表头，只允许小写字母数字下划线
+var attributeKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+func floatPtr(v float64) *float64 { return &v }
+
+// 项目没有为某个 subject 定义属性时用的默认定义（按 subject 标准名称）
+var defaultAttributeDefs = map[string][]models.AttributeDef{
+	"transformer": {
+		{Key: "kva", Label: "Rating", Type: models.AttrNumber, Unit: "kVA", Min: floatPtr(0)},
+		{Key: "primary_voltage", Label: "Primary voltage", Type: models.AttrNumber, Unit: "V", Min: floatPtr(0)},
+		{Key: "secondary_voltage", Label: "Secondary voltage", Type: models.AttrNumber, Unit: "V", Min: floatPtr(0)},
+		{Key: "impedance_pct", Label: "Impedance", Type: models.AttrNumber, Unit: "%", Min: floatPtr(0), Max: floatPtr(100)},
+		{Key: "manufacturer", Label: "Manufacturer", Type: models.AttrString},
+	},
+	"panel board": {
+		{Key: "amperage", Label: "Bus rating", Type: models.AttrNumber, Unit: "A", Min: floatPtr(0)},
+		{Key: "voltage", Label: "Voltage", Type: models.AttrNumber, Unit: "V", Min: floatPtr(0)},
+		{Key: "phase", Label: "Phase", Type: models.AttrEnum, Options: pq.StringArray{"1", "3"}},
+		{Key: "demand_factor", Label: "Demand factor", Type: models.AttrNumber, Min: floatPtr(0), Max: floatPtr(1)},
+		{Key: "manufacturer", Label: "Manufacturer", Type: models.AttrString},
+	},
+	"generator": {
+		{Key: "kw", Label: "Rating", Type: models.AttrNumber, Unit: "kW", Min: floatPtr(0)},
+		{Key: "voltage", Label: "Voltage", Type: models.AttrNumber, Unit: "V", Min: floatPtr(0)},
+		{Key: "manufacturer", Label: "Manufacturer", Type: models.AttrString},
+	},
+	"ATS": {
+		{Key: "amperage", Label: "Rating", Type: models.AttrNumber, Unit: "A", Min: floatPtr(0)},
+		{Key: "voltage", Label: "Voltage", Type: models.AttrNumber, Unit: "V", Min: floatPtr(0)},
+		{Key: "manufacturer", Label: "Manufacturer", Type: models.AttrString},
+	},
+	"Bus": {
+		{Key: "amperage", Label: "Rating", Type: models.AttrNumber, Unit: "A", Min: floatPtr(0)},
+		{Key: "voltage", Label: "Voltage", Type: models.AttrNumber, Unit: "V", Min: floatPtr(0)},
+	},
+	"Bus Duct": {
+		{Key: "amperage", Label: "Rating", Type: models.AttrNumber, Unit: "A", Min: floatPtr(0)},
+		{Key: "voltage", Label: "Voltage", Type: models.AttrNumber, Unit: "V", Min: floatPtr(0)},
+	},
+}
+
+// attributeSchemas 一个项目所有 subject 的属性定义（key 是 subject 标准名称）
+type attributeSchemas struct {
+	catalog *subjectCatalog
+	bySubj  map[string][]models.AttributeDef
+}
+
+// loadAttributeSchemas 项目自己定义过的 subject 用项目的定义，其余 subject 用默认定义
+func loadAttributeSchemas(project string) (*attributeSchemas, error) {
+	cat, err := loadSubjectCatalog(project)
+	if err != nil {
+		return nil, err
+	}
+	var defs []models.AttributeDef
+	if err := db.GetDB().Where("project = ?", project).Order("subject, sort_order, key").Find(&defs).Error; err != nil {
+		return nil, err
+	}
+
+	s := &attributeSchemas{catalog: cat, bySubj: map[string][]models.AttributeDef{}}
+	for _, d := range defs {
+		name := d.Subject
+		if e := cat.Lookup(d.Subject); e != nil {
+			name = e.Name
+		}
+		s.bySubj[name] = append(s.bySubj[name], d)
+	}
+	for subject, list := range defaultAttributeDefs {
+		name := subject
+		if e := cat.Lookup(subject); e != nil {
+			name = e.Name
+		}
+		if _, ok := s.bySubj[name]; ok {
+			continue
+		}
+		for i, d := range list {
+			d.Project = project
+			d.Subject = name
+			d.SortOrder = i
+			s.bySubj[name] = append(s.bySubj[name], d)
+		}
+	}
+	return s, nil
+}
+
+// For 按设备 subject（名字或别名）取属性定义
+func (s *attributeSchemas) For(subject string) []models.AttributeDef {
+	if e := s.catalog.Lookup(subject); e != nil {
+		return s.bySubj[e.Name]
+	}
+	return s.bySubj[subject]
+}
+
+// Keys 某个分类下所有 subject 的属性键（去重，按定义顺序），导出表头用
+func (s *attributeSchemas) Keys(category string) []string {
+	var keys []string
+	for _, e := range s.catalog.Entries {
+		if e.Category != category {
+			continue
+		}
+		for _, d := range s.bySubj[e.Name] {
+			if !slices.Contains(keys, d.Key) {
+				keys = append(keys, d.Key)
+			}
+		}
+	}
+	return keys
+}
+
+// Validate 按 subject 的定义校验属性值，返回规范化后的值（数字统一成 float64 / int64）
+func (s *attributeSchemas) Validate(subject string, attrs map[string]any) (datatypes.JSONMap, error) {
+	defs := s.For(subject)
+	byKey := make(map[string]models.AttributeDef, len(defs))
+	for _, d := range defs {
+		byKey[d.Key] = d
+	}
+
+	out := datatypes.JSONMap{}
+	for key, v := range attrs {
+		if v == nil {
+			continue
+		}
+		def, ok := byKey[key]
+		if !ok {
+			return nil, fmt.Errorf("attributes.%s: unknown attribute for subject %q", key, subject)
+		}
+		nv, err := normalizeAttribute(def, v)
+		if err != nil {
+			return nil, fmt.Errorf("attributes.%s: %v", key, err)
+		}
+		out[key] = nv
+	}
+	for _, d := range defs {
+		if _, ok := out[d.Key]; d.Required && !ok {
+			return nil, fmt.Errorf("attributes.%s: required", d.Key)
+		}
+	}
+	return out, nil
+}
+
+func normalizeAttribute(def models.AttributeDef, v any) (any, error) {
+	switch def.Type {
+	case models.AttrNumber, models.AttrInteger:
+		f, ok := attributeNumber(v)
+		if !ok {
+			return nil, errors.New("must be a number")
+		}
+		if def.Type == models.AttrInteger && f != math.Trunc(f) {
+			return nil, errors.New("must be an integer")
+		}
+		if def.Min != nil && f < *def.Min {
+			return nil, fmt.Errorf("must be >= %g", *def.Min)
+		}
+		if def.Max != nil && f > *def.Max {
+			return nil, fmt.Errorf("must be <= %g", *def.Max)
+		}
+		if def.Type == models.AttrInteger {
+			return int64(f), nil
+		}
+		return f, nil
+	case models.AttrBool:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errors.New("must be true or false")
+		}
+		return b, nil
+	case models.AttrEnum:
+		str := fmt.Sprint(v)
+		if _, isNum := attributeNumber(v); !isNum {
+			if _, isStr := v.(string); !isStr {
+				return nil, errors.New("must be a string")
+			}
+		}
+		if !slices.Contains(def.Options, str) {
+			return nil, fmt.Errorf("must be one of %s", strings.Join(def.Options, ", "))
+		}
+		return str, nil
+	default:
+		str, ok := v.(string)
+		if !ok {
+			return nil, errors.New("must be a string")
+		}
+		return str, nil
+	}
+}
+
+// attributeNumber 请求里的数字是 float64，数据库读出来的是 json.Number
+func attributeNumber(v any) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// applyAttributeFilters 列表 / 搜索接口的属性过滤：
+//
+//	attr.kva=500          等于（按字符串比较）
+//	attr.kva.min=300      数值 >=
+//	attr.kva.max=1000     数值 <=
+func applyAttributeFilters(d *gorm.DB, c *gin.Context) (*gorm.DB, error) {
+	for param, values := range c.Request.URL.Query() {
+		if !strings.HasPrefix(param, "attr.") || len(values) == 0 {
+			continue
+		}
+		key := strings.TrimPrefix(param, "attr.")
+		op := ""
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			key, op = key[:i], key[i+1:]
+		}
+		if !attributeKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid attribute filter %q", param)
+		}
+
+		// 非数字的值不参与比较，避免 ::numeric 转换报错
+		numeric := "CASE WHEN jsonb_typeof(attributes -> ?) = 'number' THEN (attributes ->> ?)::numeric END"
+		switch op {
+		case "":
+			d = d.Where("attributes ->> ? = ?", key, values[0])
+		case "min", "max":
+			f, err := strconv.ParseFloat(values[0], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number", param)
+			}
+			cmp := ">="
+			if op == "max" {
+				cmp = "<="
+			}
+			d = d.Where(numeric+" "+cmp+" ?", key, key, f)
+		default:
+			return nil, fmt.Errorf("invalid attribute filter %q", param)
+		}
+	}
+	return d, nil
+}
+
+// GET /api/v1/projects/:project/attributes?subject=transformer
+// 返回生效的属性定义（项目自定义的 + 没有自定义的 subject 的默认定义）
+func ListAttributeDefs(c *gin.Context) {
+	project := c.Param("project")
+	s, err := loadAttributeSchemas(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if subject := c.Query("subject"); subject != "" {
+		defs := s.For(subject)
+		c.JSON(http.StatusOK, gin.H{"project": project, "subject": subject, "count": len(defs), "data": defs})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"project": project, "data": s.bySubj})
+}
+
+// POST /api/v1/projects/:project/attributes
+// 某个 subject 一旦有了项目自己的定义，就不再使用该 subject 的默认定义
+func CreateAttributeDef(c *gin.Context) {
+	project := c.Param("project")
+
+	var body models.AttributeDef
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	body.ID = 0
+	body.Project = project
+
+	if ok, err := projectExists(project); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	cat, err := loadSubjectCatalog(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if e := cat.Lookup(body.Subject); e != nil {
+		body.Subject = e.Name
+	}
+	if err := validateAttributeDef(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var n int64
+	db.GetDB().Model(&models.AttributeDef{}).
+		Where("project = ? AND subject = ? AND key = ?", project, body.Subject, body.Key).
+		Count(&n)
+	if n > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "attribute already defined for this subject"})
+		return
+	}
+
+	if err := db.GetDB().Create(&body).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, body)
+}
+
+// PUT /api/v1/projects/:project/attributes/:id
+// subject 和 key 不能改（已有设备的值按 key 存储）
+func UpdateAttributeDef(c *gin.Context) {
+	type updateDTO struct {
+		Label     *string   `json:"label"`
+		Type      *string   `json:"type"`
+		Unit      *string   `json:"unit"`
+		Required  *bool     `json:"required"`
+		Options   *[]string `json:"options"`
+		Min       *float64  `json:"min"`
+		Max       *float64  `json:"max"`
+		SortOrder *int      `json:"sort_order"`
+	}
+	var req updateDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var def models.AttributeDef
+	if err := db.GetDB().First(&def, "id = ? AND project = ?", c.Param("id"), c.Param("project")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "attribute not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Label != nil {
+		def.Label = *req.Label
+	}
+	if req.Type != nil {
+		def.Type = *req.Type
+	}
+	if req.Unit != nil {
+		def.Unit = *req.Unit
+	}
+	if req.Required != nil {
+		def.Required = *req.Required
+	}
+	if req.Options != nil {
+		def.Options = pq.StringArray(*req.Options)
+	}
+	if req.Min != nil {
+		def.Min = req.Min
+	}
+	if req.Max != nil {
+		def.Max = req.Max
+	}
+	if req.SortOrder != nil {
+		def.SortOrder = *req.SortOrder
+	}
+	if err := validateAttributeDef(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.GetDB().Save(&def).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, def)
+}
+
+// DELETE /api/v1/projects/:project/attributes/:id
+// 只删定义，设备上已有的值保留
+func DeleteAttributeDef(c *gin.Context) {
+	res := db.GetDB().Delete(&models.AttributeDef{}, "id = ? AND project = ?", c.Param("id"), c.Param("project"))
+	if res.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attribute not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func validateAttributeDef(d *models.AttributeDef) error {
+	if strings.TrimSpace(d.Subject) == "" {
+		return errors.New("subject is required")
+	}
+	if !attributeKeyPattern.MatchString(d.Key) {
+		return errors.New("key must be lowercase letters, digits and underscores")
+	}
+	if !slices.Contains(attributeTypes, d.Type) {
+		return errors.New("type must be one of number, integer, string, enum, bool")
+	}
+	if d.Type == models.AttrEnum && len(d.Options) == 0 {
+		return errors.New("enum attributes need options")
+	}
+	if d.Min != nil && d.Max != nil && *d.Min > *d.Max {
+		return errors.New("min must not be greater than max")
+	}
+	if d.Label == "" {
+		d.Label = d.Key
+	}
+	return nil
+}
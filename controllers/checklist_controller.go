@@ -0,0 +1,524 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var checklistItemKinds = []string{models.ChecklistItemCheck, models.ChecklistItemNumber, models.ChecklistItemText}
+var checklistResults = []string{"", models.ChecklistPass, models.ChecklistFail, models.ChecklistNA}
+
+// GET /api/v1/projects/:project/checklist-templates?subject=transformer
+func ListChecklistTemplates(c *gin.Context) {
+	project := c.Param("project")
+
+	q := db.GetDB().Where("project = ?", project)
+	if subject := c.Query("subject"); subject != "" {
+		cat, err := loadSubjectCatalog(project)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if e := cat.Lookup(subject); e != nil {
+			subject = e.Name
+		}
+		q = q.Where("subject = ?", subject)
+	}
+
+	var list []models.ChecklistTemplate
+	if err := q.Order("subject, level, name").Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"project": project,
+		"count":   len(list),
+		"data":    list,
+	})
+}
+
+// POST /api/v1/projects/:project/checklist-templates
+func CreateChecklistTemplate(c *gin.Context) {
+	project := c.Param("project")
+
+	var body models.ChecklistTemplate
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	body.ID = 0
+	body.Project = project
+	body.Active = true
+
+	if ok, err := projectExists(project); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	if err := validateChecklistTemplate(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.GetDB().Create(&body).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, body)
+}
+
+// PUT /api/v1/projects/:project/checklist-templates/:id
+// 只影响之后生成的检查表，已经生成的实例保留当时的检查项
+func UpdateChecklistTemplate(c *gin.Context) {
+	type updateDTO struct {
+		Subject *string                         `json:"subject"`
+		Name    *string                         `json:"name"`
+		Level   *string                         `json:"level"`
+		Active  *bool                           `json:"active"`
+		Items   *[]models.ChecklistTemplateItem `json:"items"`
+	}
+	var req updateDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var t models.ChecklistTemplate
+	if err := db.GetDB().First(&t, "id = ? AND project = ?", c.Param("id"), c.Param("project")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "checklist template not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Subject != nil {
+		t.Subject = *req.Subject
+	}
+	if req.Name != nil {
+		t.Name = *req.Name
+	}
+	if req.Level != nil {
+		t.Level = *req.Level
+	}
+	if req.Active != nil {
+		t.Active = *req.Active
+	}
+	if req.Items != nil {
+		t.Items = datatypes.NewJSONType(*req.Items)
+	}
+	if err := validateChecklistTemplate(&t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.GetDB().Save(&t).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// DELETE /api/v1/projects/:project/checklist-templates/:id
+// 已经生成的检查表不受影响
+func DeleteChecklistTemplate(c *gin.Context) {
+	res := db.GetDB().Delete(&models.ChecklistTemplate{}, "id = ? AND project = ?", c.Param("id"), c.Param("project"))
+	if res.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "checklist template not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// validateChecklistTemplate subject 必须在项目目录里（统一成标准名称），检查项 key 不重复
+func validateChecklistTemplate(t *models.ChecklistTemplate) error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return errors.New("name is required")
+	}
+	cat, err := loadSubjectCatalog(t.Project)
+	if err != nil {
+		return err
+	}
+	e := cat.Lookup(t.Subject)
+	if e == nil {
+		return fmt.Errorf("unknown subject %q", t.Subject)
+	}
+	t.Subject = e.Name
+	t.Level = strings.ToUpper(strings.TrimSpace(t.Level))
+
+	items := t.Items.Data()
+	if len(items) == 0 {
+		return errors.New("items must not be empty")
+	}
+	seen := map[string]bool{}
+	for i := range items {
+		it := &items[i]
+		it.Text = strings.TrimSpace(it.Text)
+		it.Key = strings.TrimSpace(it.Key)
+		if it.Key == "" {
+			it.Key = fmt.Sprintf("item_%d", i+1)
+		}
+		if it.Text == "" {
+			return fmt.Errorf("item %d: text is required", i+1)
+		}
+		if seen[it.Key] {
+			return fmt.Errorf("item %d: duplicate key %q", i+1, it.Key)
+		}
+		seen[it.Key] = true
+		if it.Kind == "" {
+			it.Kind = models.ChecklistItemCheck
+		}
+		if !slices.Contains(checklistItemKinds, it.Kind) {
+			return fmt.Errorf("item %d: kind must be one of check, number, text", i+1)
+		}
+		if it.Kind != models.ChecklistItemNumber && (it.Min != nil || it.Max != nil) {
+			return fmt.Errorf("item %d: min / max only apply to number items", i+1)
+		}
+		if it.Min != nil && it.Max != nil && *it.Min > *it.Max {
+			return fmt.Errorf("item %d: min must not be greater than max", i+1)
+		}
+	}
+	t.Items = datatypes.NewJSONType(items)
+	return nil
+}
+
+// GET /api/v1/devices/:id/checklists
+func ListDeviceChecklists(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	var list []models.Checklist
+	if err := db.GetDB().Where("device_id = ?", deviceID).Order("level, created_at").Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range list {
+		list[i].Percent = checklistPercent(list[i].Done, list[i].Total)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id": deviceID,
+		"count":     len(list),
+		"data":      list,
+	})
+}
+
+// POST /api/v1/devices/:id/checklists
+// 请求体：{"template_id": 3}，模板的 subject 必须和设备一致
+func CreateDeviceChecklist(c *gin.Context) {
+	var req struct {
+		TemplateID uint `json:"template_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	var t models.ChecklistTemplate
+	if err := db.GetDB().First(&t, "id = ? AND project = ?", req.TemplateID, dev.Project).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "checklist template not found in device project"})
+		return
+	}
+	if !t.Active {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "checklist template is inactive"})
+		return
+	}
+	cat, err := loadSubjectCatalog(dev.Project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if e := cat.Lookup(dev.Subject); e == nil || e.Name != t.Subject {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("template is for %s, device is %s", t.Subject, dev.Subject)})
+		return
+	}
+
+	upn, _ := requestUser(c)
+	cl := models.Checklist{
+		Project:    dev.Project,
+		DeviceID:   dev.ID,
+		TemplateID: t.ID,
+		Name:       t.Name,
+		Level:      t.Level,
+		Status:     models.ChecklistOpen,
+		CreatedBy:  upn,
+	}
+	for i, it := range t.Items.Data() {
+		cl.Items = append(cl.Items, models.ChecklistItem{
+			Seq:      i + 1,
+			Key:      it.Key,
+			Text:     it.Text,
+			Kind:     it.Kind,
+			Unit:     it.Unit,
+			Min:      it.Min,
+			Max:      it.Max,
+			Required: it.Required,
+		})
+	}
+	cl.Total = len(cl.Items)
+
+	if err := db.GetDB().Create(&cl).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, cl)
+}
+
+// GET /api/v1/checklists/:id
+func GetChecklist(c *gin.Context) {
+	cl, ok := findChecklist(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, cl)
+}
+
+// DELETE /api/v1/checklists/:id
+func DeleteChecklist(c *gin.Context) {
+	id := c.Param("id")
+	err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.ChecklistItem{}, "checklist_id = ?", id).Error; err != nil {
+			return err
+		}
+		res := tx.Delete(&models.Checklist{}, "id = ?", id)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "checklist not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// PUT /api/v1/checklists/:id/items/:item
+// 请求体：{"result": "pass|fail|na|", "value": "1250", "notes": "..."}
+// number 类型的检查项只填 value 时，按模板的上下限自动判定 pass / fail。
+// 签名人取 Entra 令牌里的用户，没有登录时 401；result 置空表示撤销，同时清掉签名
+func UpdateChecklistItem(c *gin.Context) {
+	var req struct {
+		Result *string `json:"result"`
+		Value  *string `json:"value"`
+		Notes  *string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var item models.ChecklistItem
+	if err := db.GetDB().First(&item, "id = ? AND checklist_id = ?", c.Param("item"), c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "checklist item not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Value != nil {
+		item.Value = strings.TrimSpace(*req.Value)
+	}
+	if req.Notes != nil {
+		item.Notes = *req.Notes
+	}
+	if req.Result != nil {
+		item.Result = strings.ToLower(strings.TrimSpace(*req.Result))
+	} else if req.Value != nil && item.Kind == models.ChecklistItemNumber {
+		item.Result = ""
+	}
+	if err := evaluateChecklistItem(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if item.Result == "" {
+		item.SignedBy, item.SignedByOID, item.SignedAt = "", "", nil
+	} else {
+		// 签名必须有人：没有登录的请求不能签
+		upn, oid, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		now := time.Now()
+		item.SignedBy, item.SignedByOID = upn, oid
+		item.SignedAt = &now
+	}
+
+	var cl models.Checklist
+	err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&item).Error; err != nil {
+			return err
+		}
+		return refreshChecklist(tx, item.ChecklistID, &cl)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"item": item, "checklist": cl})
+}
+
+// evaluateChecklistItem 校验结果 / 测量值，number 类型没给结果时按上下限判定
+func evaluateChecklistItem(item *models.ChecklistItem) error {
+	if !slices.Contains(checklistResults, item.Result) {
+		return errors.New("result must be one of pass, fail, na or empty")
+	}
+	if item.Kind != models.ChecklistItemNumber || item.Value == "" {
+		if item.Kind == models.ChecklistItemNumber && item.Result == models.ChecklistPass && item.Required {
+			return errors.New("value is required to pass a measured item")
+		}
+		return nil
+	}
+
+	v, err := strconv.ParseFloat(item.Value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("value %q is not a number", item.Value)
+	}
+	inRange := (item.Min == nil || v >= *item.Min) && (item.Max == nil || v <= *item.Max)
+	switch item.Result {
+	case "":
+		if inRange {
+			item.Result = models.ChecklistPass
+		} else {
+			item.Result = models.ChecklistFail
+		}
+	case models.ChecklistPass:
+		if !inRange {
+			return fmt.Errorf("value %s%s is outside the accepted range", item.Value, item.Unit)
+		}
+	}
+	return nil
+}
+
+// refreshChecklist 重新统计检查表的完成情况，并把结果读回 out
+func refreshChecklist(tx *gorm.DB, checklistID uint, out *models.Checklist) error {
+	if err := tx.First(out, "id = ?", checklistID).Error; err != nil {
+		return err
+	}
+
+	var items []models.ChecklistItem
+	if err := tx.Where("checklist_id = ?", checklistID).Find(&items).Error; err != nil {
+		return err
+	}
+	out.Total, out.Done, out.Failed = len(items), 0, 0
+	for _, it := range items {
+		if it.Result != "" {
+			out.Done++
+		}
+		if it.Result == models.ChecklistFail {
+			out.Failed++
+		}
+	}
+
+	if out.Total > 0 && out.Done == out.Total {
+		if out.Status != models.ChecklistCompleted {
+			now := time.Now()
+			out.Status = models.ChecklistCompleted
+			out.CompletedAt = &now
+		}
+	} else {
+		out.Status = models.ChecklistOpen
+		out.CompletedAt = nil
+	}
+	out.Percent = checklistPercent(out.Done, out.Total)
+
+	return tx.Model(out).Select("total", "done", "failed", "status", "completed_at").Updates(out).Error
+}
+
+// findChecklist 按 :id 查检查表（带检查项）；找不到时已经写好 404
+func findChecklist(c *gin.Context) (*models.Checklist, bool) {
+	var cl models.Checklist
+	err := db.GetDB().
+		Preload("Items", func(tx *gorm.DB) *gorm.DB { return tx.Order("seq") }).
+		First(&cl, "id = ?", c.Param("id")).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "checklist not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	cl.Percent = checklistPercent(cl.Done, cl.Total)
+	return &cl, true
+}
+
+func checklistPercent(done, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(done)*1000/float64(total)) / 10
+}
+
+// fillChecklistProgress 给设备列表补上 checklist_pct（该设备所有检查表合计）
+func fillChecklistProgress(devices []models.Device) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(devices))
+	for _, d := range devices {
+		ids = append(ids, d.ID)
+	}
+
+	type progressRow struct {
+		DeviceID string
+		Total    int
+		Done     int
+	}
+	var rows []progressRow
+	if err := db.GetDB().Model(&models.Checklist{}).
+		Select("device_id, SUM(total) AS total, SUM(done) AS done").
+		Where("device_id IN ?", ids).
+		Group("device_id").
+		Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	m := make(map[string]progressRow, len(rows))
+	for _, r := range rows {
+		m[r.DeviceID] = r
+	}
+	for i := range devices {
+		if r, ok := m[devices[i].ID]; ok {
+			pct := checklistPercent(r.Done, r.Total)
+			devices[i].ChecklistPct = &pct
+		}
+	}
+	return nil
+}
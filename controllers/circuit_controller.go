@@ -0,0 +1,800 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GET /api/v1/devices/:id/circuits
+func ListPanelCircuits(c *gin.Context) {
+	panel, ok := findPanel(c)
+	if !ok {
+		return
+	}
+
+	var circuits []models.PanelCircuit
+	if err := db.GetDB().Where("panel_id = ?", panel.ID).Order("number").Find(&circuits).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"panel_id": panel.ID,
+		"count":    len(circuits),
+		"data":     circuits,
+	})
+}
+
+// POST /api/v1/devices/:id/circuits
+func CreatePanelCircuit(c *gin.Context) {
+	panel, ok := findPanel(c)
+	if !ok {
+		return
+	}
+
+	var body models.PanelCircuit
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	body.ID = 0
+	body.Project = panel.Project
+	body.PanelID = panel.ID
+	if body.Poles == 0 {
+		body.Poles = 1
+	}
+
+	others, err := panelCircuits(panel.ID, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateCircuit(panel, &body, others); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.GetDB().Create(&body).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	publishCircuitChange(c, panel.ID, body.DownstreamID)
+	c.JSON(http.StatusCreated, body)
+}
+
+// PUT /api/v1/devices/:id/circuits/:circuit
+func UpdatePanelCircuit(c *gin.Context) {
+	type updateDTO struct {
+		Number       *int     `json:"number"`
+		Poles        *int     `json:"poles"`
+		BreakerAmps  *float64 `json:"breaker_amps"`
+		Description  *string  `json:"description"`
+		LoadKVA      *float64 `json:"load_kva"`
+		DownstreamID *string  `json:"downstream_id"`
+		Notes        *string  `json:"notes"`
+	}
+	var req updateDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	panel, ok := findPanel(c)
+	if !ok {
+		return
+	}
+	ci, ok := findCircuit(c, panel.ID)
+	if !ok {
+		return
+	}
+
+	if req.Number != nil {
+		ci.Number = *req.Number
+	}
+	if req.Poles != nil {
+		ci.Poles = *req.Poles
+	}
+	if req.BreakerAmps != nil {
+		ci.BreakerAmps = *req.BreakerAmps
+	}
+	if req.Description != nil {
+		ci.Description = *req.Description
+	}
+	if req.LoadKVA != nil {
+		ci.LoadKVA = *req.LoadKVA
+	}
+	if req.DownstreamID != nil {
+		ci.DownstreamID = *req.DownstreamID
+	}
+	if req.Notes != nil {
+		ci.Notes = *req.Notes
+	}
+
+	others, err := panelCircuits(panel.ID, ci.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateCircuit(panel, ci, others); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prevDownstream := ""
+	if req.DownstreamID != nil {
+		var prev models.PanelCircuit
+		if err := db.GetDB().Select("downstream_id").First(&prev, ci.ID).Error; err == nil {
+			prevDownstream = prev.DownstreamID
+		}
+	}
+	if err := db.GetDB().Save(ci).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	publishCircuitChange(c, panel.ID, ci.DownstreamID, prevDownstream)
+	c.JSON(http.StatusOK, ci)
+}
+
+// DELETE /api/v1/devices/:id/circuits/:circuit
+func DeletePanelCircuit(c *gin.Context) {
+	var ci models.PanelCircuit
+	_ = db.GetDB().Select("downstream_id").First(&ci, "id = ? AND panel_id = ?", c.Param("circuit"), c.Param("id")).Error
+	res := db.GetDB().Delete(&models.PanelCircuit{}, "id = ? AND panel_id = ?", c.Param("circuit"), c.Param("id"))
+	if res.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "circuit not found"})
+		return
+	}
+	publishCircuitChange(c, c.Param("id"), ci.DownstreamID)
+	c.Status(http.StatusNoContent)
+}
+
+// circuitImportError CSV 导入时单行的错误，Row 是数据行号（表头之后从 1 开始）
+type circuitImportError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// CSV 表头（不区分大小写）到字段的映射，兼容几种常见写法
+var circuitCSVColumns = map[string]string{
+	"circuit":       "number",
+	"number":        "number",
+	"ckt":           "number",
+	"poles":         "poles",
+	"pole":          "poles",
+	"breaker_amps":  "breaker_amps",
+	"breaker":       "breaker_amps",
+	"trip":          "breaker_amps",
+	"description":   "description",
+	"load":          "description",
+	"load_kva":      "load_kva",
+	"kva":           "load_kva",
+	"downstream":    "downstream",
+	"downstream_id": "downstream",
+	"feeds":         "downstream",
+	"notes":         "notes",
+}
+
+// POST /api/v1/devices/:id/circuits/import?replace=1
+// Content-Type: multipart/form-data，字段 file（CSV）；也可以直接用 text/csv 请求体。
+// 按回路号 upsert；replace=1 时先清空该 panel 原有回路。
+// downstream 列可以填设备 id，也可以填同项目里唯一的设备 text。
+// 任何一行有错都不写入，返回 400 和逐行错误
+func ImportPanelCircuits(c *gin.Context) {
+	panel, ok := findPanel(c)
+	if !ok {
+		return
+	}
+
+	var src io.Reader = c.Request.Body
+	if fileHeader, err := c.FormFile("file"); err == nil {
+		f, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot open file"})
+			return
+		}
+		defer f.Close()
+		src = f
+	}
+
+	r := csv.NewReader(src)
+	r.TrimLeadingSpace = true
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid csv: " + err.Error()})
+		return
+	}
+	if len(records) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "csv has no data rows"})
+		return
+	}
+
+	cols := map[string]int{}
+	for i, h := range records[0] {
+		key := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		key = strings.ReplaceAll(key, " ", "_")
+		if field, ok := circuitCSVColumns[key]; ok {
+			if _, dup := cols[field]; !dup {
+				cols[field] = i
+			}
+		}
+	}
+	if _, ok := cols["number"]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "csv must have a circuit column"})
+		return
+	}
+
+	replace := c.Query("replace") == "1" || c.Query("replace") == "true"
+	existing := map[int]models.PanelCircuit{}
+	if !replace {
+		list, err := panelCircuits(panel.ID, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, ci := range list {
+			existing[ci.Number] = ci
+		}
+	}
+
+	resolver := &downstreamResolver{project: panel.Project, byText: map[string][]string{}}
+	var errs []circuitImportError
+	type importedRow struct {
+		row int
+		ci  *models.PanelCircuit
+	}
+	var rows []importedRow
+	imported := map[int]*models.PanelCircuit{}
+	for i, rec := range records[1:] {
+		row := i + 1
+		cell := func(field string) string {
+			if idx, ok := cols[field]; ok && idx < len(rec) {
+				return strings.TrimSpace(rec[idx])
+			}
+			return ""
+		}
+		if strings.TrimSpace(strings.Join(rec, "")) == "" {
+			continue
+		}
+
+		ci, err := circuitFromCSV(cell)
+		if err != nil {
+			errs = append(errs, circuitImportError{Row: row, Error: err.Error()})
+			continue
+		}
+		if ref := cell("downstream"); ref != "" {
+			id, err := resolver.resolve(ref)
+			if err != nil {
+				errs = append(errs, circuitImportError{Row: row, Error: err.Error()})
+				continue
+			}
+			ci.DownstreamID = id
+		}
+		if _, dup := imported[ci.Number]; dup {
+			errs = append(errs, circuitImportError{Row: row, Error: fmt.Sprintf("circuit %d appears more than once", ci.Number)})
+			continue
+		}
+		ci.Project = panel.Project
+		ci.PanelID = panel.ID
+		if old, ok := existing[ci.Number]; ok {
+			ci.ID = old.ID
+			ci.CreatedAt = old.CreatedAt
+		}
+		imported[ci.Number] = ci
+		rows = append(rows, importedRow{row: row, ci: ci})
+	}
+	if resolver.err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": resolver.err.Error()})
+		return
+	}
+
+	// 重叠检查针对导入后的完整回路表：导入的行 + 没被覆盖的原有回路
+	final := make([]models.PanelCircuit, 0, len(imported)+len(existing))
+	for n, ci := range existing {
+		if _, replaced := imported[n]; !replaced {
+			final = append(final, ci)
+		}
+	}
+	for _, r := range rows {
+		if err := validateCircuit(panel, r.ci, final); err != nil {
+			errs = append(errs, circuitImportError{Row: r.row, Error: err.Error()})
+			continue
+		}
+		final = append(final, *r.ci)
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid circuits", "errors": errs})
+		return
+	}
+
+	created, updated := 0, 0
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if replace {
+			if err := tx.Where("panel_id = ?", panel.ID).Delete(&models.PanelCircuit{}).Error; err != nil {
+				return err
+			}
+		}
+		for _, r := range rows {
+			ci := r.ci
+			if ci.ID != 0 {
+				updated++
+			} else {
+				created++
+			}
+			if err := tx.Save(ci).Error; err != nil {
+				return fmt.Errorf("circuit %d: %w", ci.Number, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 新旧回路的下游都可能变了供电来源
+	changed := []string{panel.ID}
+	for _, ci := range existing {
+		changed = append(changed, ci.DownstreamID)
+	}
+	for _, r := range rows {
+		changed = append(changed, r.ci.DownstreamID)
+	}
+	publishCircuitChange(c, changed...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"panel_id": panel.ID,
+		"count":    len(imported),
+		"created":  created,
+		"updated":  updated,
+		"replaced": replace,
+	})
+}
+
+// circuitFromCSV 把一行 CSV 转成回路（不含 downstream）
+func circuitFromCSV(cell func(string) string) (*models.PanelCircuit, error) {
+	ci := &models.PanelCircuit{Poles: 1}
+
+	n, err := strconv.Atoi(cell("number"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid circuit number %q", cell("number"))
+	}
+	ci.Number = n
+
+	if v := cell("poles"); v != "" {
+		// 允许 "2P" 这种写法
+		p, err := strconv.Atoi(strings.TrimSuffix(strings.ToUpper(v), "P"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid poles %q", v)
+		}
+		ci.Poles = p
+	}
+	if v := cell("breaker_amps"); v != "" {
+		// 允许 "20A" 这种写法
+		a, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToUpper(v), "A"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid breaker_amps %q", v)
+		}
+		ci.BreakerAmps = a
+	}
+	if v := cell("load_kva"); v != "" {
+		k, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid load_kva %q", v)
+		}
+		ci.LoadKVA = k
+	}
+	ci.Description = cell("description")
+	ci.Notes = cell("notes")
+	return ci, nil
+}
+
+// downstreamResolver 导入时把 downstream 列解析成设备 id，按 id 或唯一的 text 匹配
+type downstreamResolver struct {
+	project string
+	loaded  bool
+	ids     map[string]bool
+	byText  map[string][]string
+	err     error
+}
+
+func (r *downstreamResolver) resolve(ref string) (string, error) {
+	if !r.loaded {
+		r.loaded = true
+		var devices []models.Device
+		if err := db.GetDB().Select("id", "text").Where("project = ?", r.project).Find(&devices).Error; err != nil {
+			r.err = err
+		}
+		r.ids = make(map[string]bool, len(devices))
+		for _, d := range devices {
+			r.ids[d.ID] = true
+			key := strings.ToLower(strings.TrimSpace(d.Text))
+			if key != "" {
+				r.byText[key] = append(r.byText[key], d.ID)
+			}
+		}
+	}
+	if r.ids[ref] {
+		return ref, nil
+	}
+	switch ids := r.byText[strings.ToLower(ref)]; len(ids) {
+	case 0:
+		return "", fmt.Errorf("downstream device %q not found in project", ref)
+	case 1:
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("downstream %q matches %d devices, use the device id", ref, len(ids))
+	}
+}
+
+// scheduleSlot schedule 里的一个位置（盘面左列奇数、右列偶数）
+type scheduleSlot struct {
+	Slot         int     `json:"slot"`
+	CircuitID    uint    `json:"circuit_id,omitempty"`
+	Number       int     `json:"number,omitempty"` // 占用这个位置的回路号
+	Continuation bool    `json:"continuation,omitempty"`
+	Poles        int     `json:"poles,omitempty"`
+	BreakerAmps  float64 `json:"breaker_amps,omitempty"`
+	Description  string  `json:"description,omitempty"`
+	LoadKVA      float64 `json:"load_kva,omitempty"` // 这个位置分摊到的负载
+	DownstreamID string  `json:"downstream_id,omitempty"`
+	Downstream   string  `json:"downstream,omitempty"` // 下游设备的 text
+}
+
+type scheduleRow struct {
+	Phase string       `json:"phase"`
+	Left  scheduleSlot `json:"left"`
+	Right scheduleSlot `json:"right"`
+}
+
+type panelSchedule struct {
+	Panel       models.Device      `json:"panel"`
+	Phases      []string           `json:"phases"`
+	Rows        []scheduleRow      `json:"rows"`
+	PhaseKVA    map[string]float64 `json:"phase_kva"`
+	TotalKVA    float64            `json:"total_kva"`
+	CircuitSize int                `json:"circuit_count"`
+	GeneratedAt time.Time          `json:"generated_at"`
+}
+
+// publishCircuitChange 回路变了，panel 的盘表和下游设备的供电来源（fed-by / 拓扑）跟着变，
+// 给这些设备推 device.updated
+func publishCircuitChange(c *gin.Context, ids ...string) {
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		publishDeviceEvent(c, models.EventDeviceUpdated, id)
+	}
+}
+
+// GET /api/v1/devices/:id/schedule?format=html|csv|json
+// 按盘面排布（左奇右偶、按相轮换）渲染 schedule，并汇总每相负载
+func GetPanelSchedule(c *gin.Context) {
+	format := c.DefaultQuery("format", "html")
+	if format != "html" && format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be html, csv or json"})
+		return
+	}
+
+	panel, ok := findPanel(c)
+	if !ok {
+		return
+	}
+	circuits, err := panelCircuits(panel.ID, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	names := map[string]string{}
+	var ids []string
+	for _, ci := range circuits {
+		if ci.DownstreamID != "" {
+			ids = append(ids, ci.DownstreamID)
+		}
+	}
+	if len(ids) > 0 {
+		var devices []models.Device
+		if err := db.GetDB().Select("id", "text").Where("id IN ?", ids).Find(&devices).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, d := range devices {
+			names[d.ID] = d.Text
+		}
+	}
+
+	sched := buildPanelSchedule(*panel, circuits, names)
+	filename := fmt.Sprintf("%s_schedule_%s", panel.Text, time.Now().Format("20060102"))
+
+	switch format {
+	case "json":
+		c.JSON(http.StatusOK, sched)
+	case "csv":
+		// 列和导入一致，导出的文件可以直接再导入
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".csv"))
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		_ = w.Write([]string{"circuit", "poles", "breaker_amps", "description", "load_kva", "downstream_id", "notes"})
+		for _, ci := range circuits {
+			_ = w.Write([]string{
+				strconv.Itoa(ci.Number),
+				strconv.Itoa(ci.Poles),
+				strconv.FormatFloat(ci.BreakerAmps, 'f', -1, 64),
+				ci.Description,
+				strconv.FormatFloat(ci.LoadKVA, 'f', -1, 64),
+				ci.DownstreamID,
+				ci.Notes,
+			})
+		}
+		w.Flush()
+	default:
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := panelScheduleTemplate.Execute(c.Writer, sched); err != nil {
+			_ = c.Error(err)
+		}
+	}
+}
+
+// buildPanelSchedule 把回路排到盘面位置上；多极回路的负载平均分到它占的各相
+func buildPanelSchedule(panel models.Device, circuits []models.PanelCircuit, names map[string]string) panelSchedule {
+	phases := []string{"A", "B", "C"}
+	if v, ok := panel.Attributes["phase"]; ok && fmt.Sprint(v) == "1" {
+		phases = []string{"A", "B"}
+	}
+
+	slots := map[int]scheduleSlot{}
+	maxSlot := 2
+	for _, ci := range circuits {
+		share := ci.LoadKVA / float64(len(ci.Slots()))
+		for i, s := range ci.Slots() {
+			slots[s] = scheduleSlot{
+				Slot:         s,
+				CircuitID:    ci.ID,
+				Number:       ci.Number,
+				Continuation: i > 0,
+				Poles:        ci.Poles,
+				BreakerAmps:  ci.BreakerAmps,
+				Description:  ci.Description,
+				LoadKVA:      share,
+				DownstreamID: ci.DownstreamID,
+				Downstream:   names[ci.DownstreamID],
+			}
+			if s > maxSlot {
+				maxSlot = s
+			}
+		}
+	}
+
+	sched := panelSchedule{
+		Panel:       panel,
+		Phases:      phases,
+		PhaseKVA:    map[string]float64{},
+		CircuitSize: len(circuits),
+		GeneratedAt: time.Now(),
+	}
+	for _, p := range phases {
+		sched.PhaseKVA[p] = 0
+	}
+	for r := 1; 2*r-1 <= maxSlot; r++ {
+		row := scheduleRow{Phase: phases[(r-1)%len(phases)]}
+		row.Left, row.Right = slotOrEmpty(slots, 2*r-1), slotOrEmpty(slots, 2*r)
+		sched.PhaseKVA[row.Phase] += row.Left.LoadKVA + row.Right.LoadKVA
+		sched.Rows = append(sched.Rows, row)
+	}
+	for p, v := range sched.PhaseKVA {
+		sched.PhaseKVA[p] = math.Round(v*1000) / 1000
+		sched.TotalKVA += v
+	}
+	sched.TotalKVA = math.Round(sched.TotalKVA*1000) / 1000
+	return sched
+}
+
+func slotOrEmpty(slots map[int]scheduleSlot, n int) scheduleSlot {
+	if s, ok := slots[n]; ok {
+		return s
+	}
+	return scheduleSlot{Slot: n}
+}
+
+var panelScheduleTemplate = template.Must(template.New("schedule").Funcs(template.FuncMap{
+	"kva": func(v float64) string {
+		if v == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	},
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Panel {{.Panel.Text}}</title>
+<style>
+body{font-family:Arial,Helvetica,sans-serif;font-size:12px}
+table{border-collapse:collapse;width:100%}
+th,td{border:1px solid #444;padding:3px 6px}
+th{background:#eee}
+td.num{text-align:center;width:3em}
+td.kva{text-align:right;width:5em}
+td.ph{text-align:center;font-weight:bold;background:#f6f6f6}
+td.cont{color:#888}
+</style></head><body>
+<h2>Panel {{.Panel.Text}}</h2>
+<p>Project {{.Panel.Project}} &middot; page {{.Panel.FilePage}} &middot; {{.CircuitSize}} circuits &middot; generated {{.GeneratedAt.Format "2006-01-02 15:04"}}</p>
+<table>
+<tr><th>Load</th><th>kVA</th><th>Trip</th><th>Ckt</th><th>Ph</th><th>Ckt</th><th>Trip</th><th>kVA</th><th>Load</th></tr>
+{{range .Rows}}<tr>
+{{with .Left}}<td{{if .Continuation}} class="cont"{{end}}>{{if .Continuation}}&#8627; ckt {{.Number}}{{else}}{{.Description}}{{if .Downstream}} &rarr; {{.Downstream}}{{end}}{{end}}</td><td class="kva">{{kva .LoadKVA}}</td><td class="num">{{if and .Number (not .Continuation)}}{{.BreakerAmps}}/{{.Poles}}P{{end}}</td><td class="num">{{.Slot}}</td>{{end}}
+<td class="ph">{{.Phase}}</td>
+{{with .Right}}<td class="num">{{.Slot}}</td><td class="num">{{if and .Number (not .Continuation)}}{{.BreakerAmps}}/{{.Poles}}P{{end}}</td><td class="kva">{{kva .LoadKVA}}</td><td{{if .Continuation}} class="cont"{{end}}>{{if .Continuation}}&#8627; ckt {{.Number}}{{else}}{{.Description}}{{if .Downstream}} &rarr; {{.Downstream}}{{end}}{{end}}</td>{{end}}
+</tr>
+{{end}}</table>
+<h3>Connected load</h3>
+<table style="width:auto">
+<tr>{{range .Phases}}<th>Phase {{.}}</th>{{end}}<th>Total</th></tr>
+<tr>{{range .Phases}}<td class="kva">{{kva (index $.PhaseKVA .)}}</td>{{end}}<td class="kva">{{kva .TotalKVA}}</td></tr>
+</table>
+</body></html>
+`))
+
+// GET /api/v1/devices/:id/fed-by
+// 反查哪个 panel 的哪一路回路给这个设备供电
+func GetDeviceFedBy(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", deviceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	var circuits []models.PanelCircuit
+	if err := db.GetDB().Where("downstream_id = ?", deviceID).Order("panel_id, number").Find(&circuits).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	panels := map[string]models.Device{}
+	if len(circuits) > 0 {
+		ids := make([]string, 0, len(circuits))
+		for _, ci := range circuits {
+			ids = append(ids, ci.PanelID)
+		}
+		var list []models.Device
+		if err := db.GetDB().Where("id IN ?", ids).Find(&list).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, p := range list {
+			panels[p.ID] = p
+		}
+	}
+
+	type fedByItem struct {
+		models.PanelCircuit
+		Panel *models.Device `json:"panel"`
+	}
+	out := make([]fedByItem, 0, len(circuits))
+	for _, ci := range circuits {
+		item := fedByItem{PanelCircuit: ci}
+		if p, ok := panels[ci.PanelID]; ok {
+			item.Panel = &p
+		}
+		out = append(out, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id": deviceID,
+		"count":     len(out),
+		"data":      out,
+	})
+}
+
+// findPanel 按 :id 查 panel；设备不存在返回 404，不是 equipment 返回 400
+func findPanel(c *gin.Context) (*models.Device, bool) {
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	cat, err := loadSubjectCatalog(dev.Project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if cat.Category(dev.Subject) != models.SubjectEquipment {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("device %s (%s) cannot have circuits", dev.ID, dev.Subject)})
+		return nil, false
+	}
+	return &dev, true
+}
+
+// findCircuit 按 :circuit 查 panel 下的回路；找不到时已经写好 404
+func findCircuit(c *gin.Context, panelID string) (*models.PanelCircuit, bool) {
+	var ci models.PanelCircuit
+	if err := db.GetDB().First(&ci, "id = ? AND panel_id = ?", c.Param("circuit"), panelID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "circuit not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &ci, true
+}
+
+// panelCircuits panel 的所有回路，excludeID 非 0 时排除这一条（更新时用）
+func panelCircuits(panelID string, excludeID uint) ([]models.PanelCircuit, error) {
+	var list []models.PanelCircuit
+	q := db.GetDB().Where("panel_id = ?", panelID)
+	if excludeID != 0 {
+		q = q.Where("id <> ?", excludeID)
+	}
+	err := q.Order("number").Find(&list).Error
+	return list, err
+}
+
+// validateCircuit 检查字段范围、占用位置不和 others 重叠，以及下游设备存在
+func validateCircuit(panel *models.Device, ci *models.PanelCircuit, others []models.PanelCircuit) error {
+	ci.Description = strings.TrimSpace(ci.Description)
+	ci.DownstreamID = strings.TrimSpace(ci.DownstreamID)
+	if ci.Number < 1 {
+		return errors.New("number must be at least 1")
+	}
+	if ci.Poles < 1 || ci.Poles > 3 {
+		return errors.New("poles must be 1, 2 or 3")
+	}
+	if ci.BreakerAmps < 0 || ci.LoadKVA < 0 {
+		return errors.New("breaker_amps and load_kva must not be negative")
+	}
+
+	taken := map[int]int{}
+	for _, o := range others {
+		for _, s := range o.Slots() {
+			taken[s] = o.Number
+		}
+	}
+	for _, s := range ci.Slots() {
+		if n, ok := taken[s]; ok {
+			return fmt.Errorf("circuit %d overlaps circuit %d at position %d", ci.Number, n, s)
+		}
+	}
+
+	if ci.DownstreamID != "" {
+		if ci.DownstreamID == panel.ID {
+			return errors.New("a circuit cannot feed its own panel")
+		}
+		var n int64
+		if err := db.GetDB().Model(&models.Device{}).
+			Where("id = ? AND project = ?", ci.DownstreamID, panel.Project).
+			Count(&n).Error; err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("downstream device %s not found in project %s", ci.DownstreamID, panel.Project)
+		}
+	}
+	return nil
+}
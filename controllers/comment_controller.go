@@ -0,0 +1,348 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// @提及：@ 后面跟 UPN（例如 @jane.doe@contoso.com）或用户名
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w.])@([\w.%+-]+(?:@[\w-]+(?:\.[\w-]+)+)?)`)
+
+// parseMentions 从正文里提取被 @ 的用户，合并 extra，去重并统一小写
+func parseMentions(body string, extra []string) pq.StringArray {
+	var out pq.StringArray
+	add := func(u string) {
+		u = strings.ToLower(strings.TrimRight(strings.TrimSpace(u), "."))
+		if u != "" && !slices.Contains(out, u) {
+			out = append(out, u)
+		}
+	}
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		add(m[1])
+	}
+	for _, u := range extra {
+		add(strings.TrimPrefix(u, "@"))
+	}
+	return out
+}
+
+type commentRequest struct {
+	Body     *string  `json:"body"`
+	Mentions []string `json:"mentions"`
+	FileIDs  *[]int64 `json:"file_ids"`
+}
+
+// GET /api/v1/devices/:id/comments
+// 按时间顺序返回整个线程
+func ListDeviceComments(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	var list []models.DeviceComment
+	if err := db.GetDB().Where("device_id = ?", deviceID).Order("created_at, id").Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := attachCommentFiles(list); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"device_id": deviceID,
+		"count":     len(list),
+		"data":      list,
+	})
+}
+
+// POST /api/v1/devices/:id/comments
+// 需要登录，作者取令牌里的用户。请求体：{"body": "breaker tripped, @jane.doe@contoso.com please check", "file_ids": [12]}
+func CreateDeviceComment(c *gin.Context) {
+	var req commentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Body == nil || strings.TrimSpace(*req.Body) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body is required"})
+		return
+	}
+
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	upn, oid, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	cm := models.DeviceComment{
+		Project:   dev.Project,
+		DeviceID:  dev.ID,
+		Body:      strings.TrimSpace(*req.Body),
+		Author:    upn,
+		AuthorOID: oid,
+	}
+	cm.Mentions = parseMentions(cm.Body, req.Mentions)
+	if req.FileIDs != nil {
+		if err := checkCommentFiles(dev.ID, *req.FileIDs); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		cm.FileIDs = pq.Int64Array(*req.FileIDs)
+	}
+
+	if err := db.GetDB().Create(&cm).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	one := []models.DeviceComment{cm}
+	_ = attachCommentFiles(one)
+	publishEvent(cm.Project, models.EventCommentCreated, cm.DeviceID, one[0], upn)
+	c.JSON(http.StatusCreated, one[0])
+}
+
+// PUT /api/v1/comments/:id
+// 需要登录。只有作者本人可以修改；迁移过来的旧评论没有作者，登录用户都可以整理
+func UpdateDeviceComment(c *gin.Context) {
+	var req commentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, _, ok := requireUser(c); !ok {
+		return
+	}
+	cm, ok := findComment(c)
+	if !ok {
+		return
+	}
+	if !canEditComment(c, cm) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the author can edit this comment"})
+		return
+	}
+
+	if req.Body != nil {
+		body := strings.TrimSpace(*req.Body)
+		if body == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "body must not be empty"})
+			return
+		}
+		cm.Body = body
+	}
+	if req.Body != nil || req.Mentions != nil {
+		cm.Mentions = parseMentions(cm.Body, req.Mentions)
+	}
+	if req.FileIDs != nil {
+		if err := checkCommentFiles(cm.DeviceID, *req.FileIDs); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		cm.FileIDs = pq.Int64Array(*req.FileIDs)
+	}
+	now := time.Now()
+	cm.EditedAt = &now
+
+	if err := db.GetDB().Save(cm).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	one := []models.DeviceComment{*cm}
+	_ = attachCommentFiles(one)
+	upn, _ := requestUser(c)
+	publishEvent(cm.Project, models.EventCommentUpdated, cm.DeviceID, one[0], upn)
+	c.JSON(http.StatusOK, one[0])
+}
+
+// DELETE /api/v1/comments/:id
+// 权限同修改
+func DeleteDeviceComment(c *gin.Context) {
+	if _, _, ok := requireUser(c); !ok {
+		return
+	}
+	cm, ok := findComment(c)
+	if !ok {
+		return
+	}
+	if !canEditComment(c, cm) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the author can delete this comment"})
+		return
+	}
+	if err := db.GetDB().Delete(cm).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db delete failed"})
+		return
+	}
+	upn, _ := requestUser(c)
+	publishEvent(cm.Project, models.EventCommentDeleted, cm.DeviceID, gin.H{"id": cm.ID, "device_id": cm.DeviceID}, upn)
+	c.Status(http.StatusNoContent)
+}
+
+// GET /api/v1/projects/:project/comments/recent?limit=50&since=2025-01-01T00:00:00Z&mention=jane.doe@contoso.com
+// 项目里最新的评论（新的在前），带上设备的 text / subject
+func ListRecentComments(c *gin.Context) {
+	project := c.Param("project")
+
+	limit := 50
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+		limit = n
+	}
+
+	q := db.GetDB().Where("project = ?", project)
+	if v := c.Query("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be RFC3339"})
+			return
+		}
+		q = q.Where("created_at > ?", since)
+	}
+	if v := c.Query("mention"); v != "" {
+		q = q.Where("? = ANY(mentions)", strings.ToLower(strings.TrimPrefix(v, "@")))
+	}
+	if v := c.Query("author"); v != "" {
+		q = q.Where("author = ?", v)
+	}
+
+	var list []models.DeviceComment
+	if err := q.Order("created_at DESC, id DESC").Limit(limit).Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := attachCommentFiles(list); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	devices := map[string]models.Device{}
+	if len(list) > 0 {
+		ids := make([]string, 0, len(list))
+		for _, cm := range list {
+			ids = append(ids, cm.DeviceID)
+		}
+		var devs []models.Device
+		if err := db.GetDB().Select("id", "text", "subject", "file_page").Where("id IN ?", ids).Find(&devs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, d := range devs {
+			devices[d.ID] = d
+		}
+	}
+
+	type recentComment struct {
+		models.DeviceComment
+		DeviceText    string `json:"device_text"`
+		DeviceSubject string `json:"device_subject"`
+		FilePage      int    `json:"file_page"`
+	}
+	out := make([]recentComment, 0, len(list))
+	for _, cm := range list {
+		d := devices[cm.DeviceID]
+		out = append(out, recentComment{DeviceComment: cm, DeviceText: d.Text, DeviceSubject: d.Subject, FilePage: d.FilePage})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project": project,
+		"count":   len(out),
+		"data":    out,
+	})
+}
+
+// findComment 按 :id 查评论；找不到时已经写好 404
+func findComment(c *gin.Context) (*models.DeviceComment, bool) {
+	var cm models.DeviceComment
+	if err := db.GetDB().First(&cm, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &cm, true
+}
+
+// canEditComment 作者本人可以修改 / 删除；迁移过来的旧评论（legacy）没有作者，登录用户都可以整理。
+// 调用方先用 requireUser 确认已登录
+func canEditComment(c *gin.Context, cm *models.DeviceComment) bool {
+	upn, _ := requestUser(c)
+	if upn == "" {
+		return false
+	}
+	return cm.Legacy || strings.EqualFold(upn, cm.Author)
+}
+
+// errCommentsMoved 设备备注已经改成评论线程。comments 列只留给建筑类标注存房间 / 楼层名
+var errCommentsMoved = errors.New("device notes are kept as comments, post them to /api/v1/devices/:id/comments")
+
+// checkCommentsWritable 只有建筑类（墙、房间线、楼层线等）的 comments 还能直接改
+func checkCommentsWritable(project, subject string) error {
+	cat, err := loadSubjectCatalog(project)
+	if err != nil {
+		return err
+	}
+	if cat.Category(subject) != models.SubjectArchitectural {
+		return errCommentsMoved
+	}
+	return nil
+}
+
+// checkCommentFiles 附件必须是同一个设备的文件
+func checkCommentFiles(deviceID string, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var n int64
+	if err := db.GetDB().Model(&models.DeviceFile{}).Where("id IN ? AND device_id = ?", ids, deviceID).Count(&n).Error; err != nil {
+		return err
+	}
+	if int(n) != len(slices.Compact(slices.Sorted(slices.Values(ids)))) {
+		return errors.New("file_ids must reference files of this device")
+	}
+	return nil
+}
+
+// attachCommentFiles 按 FileIDs 补上附件信息（已删除的文件会被跳过）
+func attachCommentFiles(list []models.DeviceComment) error {
+	var ids []int64
+	for _, cm := range list {
+		ids = append(ids, cm.FileIDs...)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var files []models.DeviceFile
+	if err := db.GetDB().Where("id IN ?", ids).Find(&files).Error; err != nil {
+		return err
+	}
+	byID := make(map[int64]models.DeviceFile, len(files))
+	for _, f := range files {
+		byID[int64(f.ID)] = f
+	}
+	for i := range list {
+		for _, id := range list[i].FileIDs {
+			if f, ok := byID[id]; ok {
+				list[i].Attachments = append(list[i].Attachments, f)
+			}
+		}
+	}
+	return nil
+}
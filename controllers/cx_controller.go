@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// unmetPrerequisite 一条没满足的前置条件，409 时逐条返回给前端
+type unmetPrerequisite struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// cxLevelIndex 等级在 CxLevels 里的位置，未开始（空）为 -1
+func cxLevelIndex(level string) int {
+	return slices.Index(models.CxLevels, level)
+}
+
+// cxNextLevel 下一个等级，已经是 L5 时返回空
+func cxNextLevel(level string) string {
+	i := cxLevelIndex(level) + 1
+	if i >= len(models.CxLevels) {
+		return ""
+	}
+	return models.CxLevels[i]
+}
+
+// checkCxEvidence 检查设备推进到 level 需要的证据，返回没满足的条目
+func checkCxEvidence(dev *models.Device, req models.CxRequirement, level string) ([]unmetPrerequisite, error) {
+	var unmet []unmetPrerequisite
+
+	missing, err := missingFileTypes(dev.ID, req.FileTypes)
+	if err != nil {
+		return nil, err
+	}
+	unmet = append(unmet, missing...)
+
+	if req.RequireChecklist {
+		var lists []models.Checklist
+		if err := db.GetDB().Where("device_id = ? AND level = ?", dev.ID, level).Find(&lists).Error; err != nil {
+			return nil, err
+		}
+		if len(lists) == 0 {
+			unmet = append(unmet, unmetPrerequisite{Code: "missing_checklist", Detail: fmt.Sprintf("no %s checklist", level)})
+		}
+		unmet = append(unmet, checklistUnmet(lists)...)
+	}
+	return unmet, nil
+}
+
+// missingFileTypes 设备上还没有上传的文件类型
+func missingFileTypes(deviceID string, types []string) ([]unmetPrerequisite, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+	var have []string
+	if err := db.GetDB().Model(&models.DeviceFile{}).
+		Where("device_id = ? AND file_type IN ?", deviceID, types).
+		Distinct().Pluck("file_type", &have).Error; err != nil {
+		return nil, err
+	}
+	var unmet []unmetPrerequisite
+	for _, t := range types {
+		if !slices.Contains(have, t) {
+			unmet = append(unmet, unmetPrerequisite{Code: "missing_file", Detail: fmt.Sprintf("no %s file uploaded", t)})
+		}
+	}
+	return unmet, nil
+}
+
+// checklistUnmet 没完成或有 fail 的检查表
+func checklistUnmet(lists []models.Checklist) []unmetPrerequisite {
+	var unmet []unmetPrerequisite
+	for _, cl := range lists {
+		switch {
+		case cl.Status != models.ChecklistCompleted:
+			unmet = append(unmet, unmetPrerequisite{Code: "checklist_incomplete", Detail: fmt.Sprintf("checklist %q is %d/%d done", cl.Name, cl.Done, cl.Total)})
+		case cl.Failed > 0:
+			unmet = append(unmet, unmetPrerequisite{Code: "checklist_failed", Detail: fmt.Sprintf("checklist %q has %d failed items", cl.Name, cl.Failed)})
+		}
+	}
+	return unmet
+}
+
+// GET /api/v1/devices/:id/cx
+// 当前调试等级、下一等级的证据检查结果和变更历史
+func GetDeviceCx(c *gin.Context) {
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	var history []models.CxTransition
+	if err := db.GetDB().Where("device_id = ?", dev.ID).Order("created_at DESC, id DESC").Find(&history).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := gin.H{
+		"device_id":     dev.ID,
+		"cx_level":      dev.CxLevel,
+		"cx_level_name": models.CxLevelNames[dev.CxLevel],
+		"cx_level_at":   dev.CxLevelAt,
+		"transitions":   history,
+	}
+	if next := cxNextLevel(dev.CxLevel); next != "" {
+		req := loadProjectSettings(dev.Project).CxRequirements[next]
+		unmet, err := checkCxEvidence(&dev, req, next)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		resp["next_level"] = next
+		resp["next_requirements"] = req
+		resp["unmet"] = unmet
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// POST /api/v1/devices/:id/cx/transitions
+// 请求体：{"to_level": "L2", "notes": "..."}
+// 只能逐级推进，推进前检查目标等级要求的证据，不满足返回 409 和 unmet 列表；
+// 退回到更低等级（或清空）必须写 notes 说明原因。变更要记录操作人，必须登录
+func CreateCxTransition(c *gin.Context) {
+	var req struct {
+		ToLevel string `json:"to_level"`
+		Notes   string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	upn, oid, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	req.ToLevel = strings.ToUpper(strings.TrimSpace(req.ToLevel))
+	req.Notes = strings.TrimSpace(req.Notes)
+	if req.ToLevel != "" && cxLevelIndex(req.ToLevel) < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to_level must be one of L1, L2, L3, L4, L5 or empty"})
+		return
+	}
+
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	from, to := cxLevelIndex(dev.CxLevel), cxLevelIndex(req.ToLevel)
+	switch {
+	case to == from:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device is already at " + dev.CxLevel})
+		return
+	case to > from+1:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("cannot skip from %q to %s, next level is %s", dev.CxLevel, req.ToLevel, cxNextLevel(dev.CxLevel))})
+		return
+	case to < from && req.Notes == "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "notes are required when moving back a level"})
+		return
+	case to > from:
+		cxReq := loadProjectSettings(dev.Project).CxRequirements[req.ToLevel]
+		unmet, err := checkCxEvidence(&dev, cxReq, req.ToLevel)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(unmet) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "prerequisites not met for " + req.ToLevel, "unmet": unmet})
+			return
+		}
+	}
+
+	now := time.Now()
+	t := models.CxTransition{
+		Project:      dev.Project,
+		DeviceID:     dev.ID,
+		FromLevel:    dev.CxLevel,
+		ToLevel:      req.ToLevel,
+		Notes:        req.Notes,
+		ChangedBy:    upn,
+		ChangedByOID: oid,
+	}
+	err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		// 条件里带上原等级，防止两个请求同时推进；加列前已有的设备 cx_level 是 NULL
+		res := tx.Model(&models.Device{}).
+			Where("id = ? AND COALESCE(cx_level, '') = ?", dev.ID, dev.CxLevel).
+			Updates(map[string]any{"cx_level": req.ToLevel, "cx_level_at": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errCxLevelChanged
+		}
+		return tx.Create(&t).Error
+	})
+	if errors.Is(err, errCxLevelChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	publishDeviceEvent(c, models.EventDeviceUpdated, dev.ID)
+	c.JSON(http.StatusCreated, t)
+}
+
+var errCxLevelChanged = errors.New("cx level was changed by another request, reload and retry")
+
+// GET /api/v1/projects/:project/cx-progress
+// 按 subject 统计 equipment 在各调试等级的数量；reached 是达到（含超过）该等级的数量
+func GetCxProgress(c *gin.Context) {
+	project := c.Param("project")
+
+	cat, err := loadSubjectCatalog(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	type countRow struct {
+		Subject string
+		CxLevel string
+		Count   int
+	}
+	var rows []countRow
+	if err := db.GetDB().Model(&models.Device{}).
+		Select("subject, cx_level, COUNT(*) AS count").
+		Where("project = ? AND LOWER(subject) IN ?", project, cat.MatchKeys(models.SubjectEquipment)).
+		Group("subject, cx_level").
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	type subjectProgress struct {
+		Subject string         `json:"subject"`
+		Total   int            `json:"total"`
+		Counts  map[string]int `json:"counts"`  // 当前等级 -> 数量，"" 为未开始
+		Reached map[string]int `json:"reached"` // 达到该等级及以上的数量
+	}
+	newProgress := func(subject string) *subjectProgress {
+		p := &subjectProgress{Subject: subject, Counts: map[string]int{"": 0}, Reached: map[string]int{}}
+		for _, l := range models.CxLevels {
+			p.Counts[l] = 0
+			p.Reached[l] = 0
+		}
+		return p
+	}
+
+	bySubject := map[string]*subjectProgress{}
+	total := newProgress("")
+	for _, r := range rows {
+		// 别名统一到标准名称
+		name := r.Subject
+		if e := cat.Lookup(r.Subject); e != nil {
+			name = e.Name
+		}
+		p, ok := bySubject[name]
+		if !ok {
+			p = newProgress(name)
+			bySubject[name] = p
+		}
+		for _, agg := range []*subjectProgress{p, total} {
+			agg.Total += r.Count
+			agg.Counts[r.CxLevel] += r.Count
+			for i := 0; i <= cxLevelIndex(r.CxLevel); i++ {
+				agg.Reached[models.CxLevels[i]] += r.Count
+			}
+		}
+	}
+
+	out := make([]*subjectProgress, 0, len(bySubject))
+	for _, p := range bySubject {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Subject < out[j].Subject })
+
+	c.JSON(http.StatusOK, gin.H{
+		"project": project,
+		"levels":  models.CxLevelNames,
+		"total":   total,
+		"data":    out,
+	})
+}
@@ -9,11 +9,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 type PaginationQuery struct {
@@ -34,14 +36,22 @@ func ListDevices(c *gin.Context) {
 	var items []models.Device
 	var total int64
 
-	d := db.GetDB()
-	d.Model(&models.Device{}).Count(&total)
+	d, err := applyAttributeFilters(db.GetDB().Model(&models.Device{}), c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	d.Count(&total)
 
 	offset := (q.Page - 1) * q.Size
 	if err := d.Order("updated_at DESC").Limit(q.Size).Offset(offset).Find(&items).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if err := fillChecklistProgress(items); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"data":       items,
 		"pagination": gin.H{"page": q.Page, "size": q.Size, "total": total},
@@ -60,7 +70,13 @@ func GetDevice(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, dev)
+	one := []models.Device{dev}
+	if err := fillChecklistProgress(one); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("ETag", deviceETag(&dev))
+	c.JSON(http.StatusOK, one[0])
 }
 
 // GET /api/v1/projects/:project/devices
@@ -69,7 +85,16 @@ func GetDevicesByProject(c *gin.Context) {
 	project := c.Param("project")
 	var devices []models.Device
 
-	if err := db.GetDB().Where("project = ?", project).Order("updated_at DESC").Find(&devices).Error; err != nil {
+	d, err := applyAttributeFilters(db.GetDB().Where("project = ?", project), c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := d.Order("updated_at DESC").Find(&devices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := fillChecklistProgress(devices); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -82,7 +107,8 @@ func GetDevicesByProject(c *gin.Context) {
 }
 
 // GET /api/v1/projects/:project/equipments
-// 只返回 subject 是 panel board / transformer / Generator 的设备
+// 只返回 subject 在项目 subject 目录里属于 equipment 分类的设备
+// （名字和别名都不区分大小写，默认 panel board / transformer / generator / ATS）
 // 行为：
 // 1. 不传 page/size => 返回全部，不计算 file_count，不返回 pagination
 // 2. 传了 page 或 size 任意一个 => 分页 + 计算每个设备的 file_count + 返回 pagination
@@ -91,7 +117,19 @@ func GetEquipmentsByProject(c *gin.Context) {
 	dbx := db.GetDB()
 
 	// 要的 subject 类型
-	equipmentSubjects := []string{"panel board", "transformer", "Generator", "ATS"}
+	cat, err := loadSubjectCatalog(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	equipmentSubjects := cat.MatchKeys(models.SubjectEquipment)
+
+	// 属性过滤（attr.kva.min=500 之类）
+	filtered, err := applyAttributeFilters(dbx.Model(&models.Device{}), c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// 看看有没有传分页参数
 	pageStr := c.Query("page")
@@ -100,14 +138,18 @@ func GetEquipmentsByProject(c *gin.Context) {
 	// ===== 情况一：不传 page / size，返回所有设备，不算 file_count =====
 	if pageStr == "" && sizeStr == "" {
 		var devices []models.Device
-		if err := dbx.
-			Where("project = ? AND subject IN ?", project, equipmentSubjects).
+		if err := filtered.
+			Where("project = ? AND LOWER(subject) IN ?", project, equipmentSubjects).
 			Order("updated_at DESC").
 			Find(&devices).Error; err != nil {
 
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		if err := fillChecklistProgress(devices); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"project": project,
@@ -124,8 +166,8 @@ func GetEquipmentsByProject(c *gin.Context) {
 	}
 
 	// 基础查询：限定项目 + subject
-	base := dbx.Model(&models.Device{}).
-		Where("project = ? AND subject IN ?", project, equipmentSubjects)
+	base := filtered.
+		Where("project = ? AND LOWER(subject) IN ?", project, equipmentSubjects)
 
 	// 统计总数
 	var total int64
@@ -147,7 +189,11 @@ func GetEquipmentsByProject(c *gin.Context) {
 		return
 	}
 
-	// 为分页结果补充 file_count
+	// 为分页结果补充 file_count 和检查表进度
+	if err := fillChecklistProgress(devices); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	if len(devices) > 0 {
 		ids := make([]string, 0, len(devices))
 		for _, d := range devices {
@@ -202,19 +248,74 @@ func CreateDevice(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "id is required"})
 		return
 	}
+	// 调试等级只能通过 cx transitions 接口推进，当前电源只能通过 sources/transfer 切换，
+	// 开关位置只能通过 operate 接口修改；版本号由服务器维护
+	body.CxLevel, body.CxLevelAt = "", nil
+	body.ActiveSource, body.Position = "", ""
+	body.Version = 0
+	if err := validateSourceRole(body.SourceRole); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if ok, err := projectExists(body.Project); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown project %q", body.Project)})
+		return
+	}
+	if err := body.ValidateGeometry(pageBounds(body.Project, body.FilePage)); err != nil {
+		geometryError(c, err)
+		return
+	}
+	schemas, err := loadAttributeSchemas(body.Project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	attrs, err := schemas.Validate(body.Subject, body.Attributes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	body.Attributes = attrs
+	cat := schemas.catalog
+	if body.Comments != "" && cat.Category(body.Subject) != models.SubjectArchitectural {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errCommentsMoved.Error()})
+		return
+	}
+
+	// 新建时就送电也要过上锁挂牌和送电许可，和 PUT 一样。
+	// 新建不能 override：不满足许可的先按未送电建好，再用 PUT 带 override 放行
+	if body.Energized || body.EnergizedToday {
+		if rejectIfLockedOut(c, &body) {
+			return
+		}
+	}
+	if body.Energized {
+		pending := body
+		pending.Energized = false
+		if _, ok := enforceEnergizationGate(c, &pending, nil); !ok {
+			return
+		}
+	}
+
 	if err := db.GetDB().Create(&body).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if body.Subject == "panel board" {
+	if cat.Energizable(body.Subject) {
 		_ = setConnectedPolylinesEnergized([]string{body.ID}, body.Energized)
 		_ = setConnectedPolylinesEnergizedToday([]string{body.ID}, body.EnergizedToday)
 	}
+	upn, _ := requestUser(c)
+	publishEvent(body.Project, models.EventDeviceCreated, body.ID, body, upn)
 	c.JSON(http.StatusCreated, body)
 }
 
 // PUT /api/v1/devices/:id
+// 带 If-Match（GET 返回的 ETag）时只有版本一致才修改，否则返回 412 和设备当前状态
 func UpdateDevice(c *gin.Context) {
 	id := c.Param("id")
 
@@ -226,6 +327,17 @@ func UpdateDevice(c *gin.Context) {
 		Energized       *bool      `json:"energized"`
 		EnergizedToday  *bool      `json:"energized_today"`
 		WillEnergizedAt *time.Time `json:"will_energized_at"`
+		SourceRole      *string    `json:"source_role"`
+
+		RectPX          *[]int64           `json:"rect_px"`
+		PolygonPointsPX *models.PolylinePX `json:"polygon_points_px"`
+		ShortSegmentsPX *models.SegmentsPX `json:"short_segments_px"`
+
+		// 和已有属性合并，值为 null 的键会被删除
+		Attributes map[string]any `json:"attributes"`
+
+		// 送电许可不通过时强制送电，需要原因和 override 角色
+		Override *energizeOverride `json:"override"`
 	}
 	var req updateDTO
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -242,12 +354,61 @@ func UpdateDevice(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if !deviceIfMatch(c, &dev) {
+		return
+	}
+
+	// 只校验这次传了的几何字段，页面尺寸已登记时同时检查越界
+	bounds := pageBounds(dev.Project, dev.FilePage)
+	if req.RectPX != nil {
+		if err := models.ValidateRect(*req.RectPX, bounds); err != nil {
+			geometryError(c, err)
+			return
+		}
+	}
+	if req.PolygonPointsPX != nil {
+		if err := req.PolygonPointsPX.Validate(bounds); err != nil {
+			geometryError(c, err)
+			return
+		}
+	}
+	if req.ShortSegmentsPX != nil {
+		if err := req.ShortSegmentsPX.Validate(bounds); err != nil {
+			geometryError(c, err)
+			return
+		}
+	}
+
+	// 上锁挂牌：被锁住的支路不能送电，也不能 override
+	if (req.Energized != nil && *req.Energized) || (req.EnergizedToday != nil && *req.EnergizedToday) {
+		if rejectIfLockedOut(c, &dev) {
+			return
+		}
+	}
+
+	// 送电许可：项目启用后，energized 从 false 改成 true 前检查前置条件
+	var overrideAudit *models.EnergizationOverride
+	if req.Energized != nil && *req.Energized {
+		audit, ok := enforceEnergizationGate(c, &dev, req.Override)
+		if !ok {
+			return
+		}
+		overrideAudit = audit
+	}
 
 	changes := map[string]any{}
 	if req.Text != nil {
 		changes["text"] = *req.Text
 	}
 	if req.Comments != nil {
+		subject := dev.Subject
+		if req.Subject != nil {
+			subject = *req.Subject
+		}
+		if err := checkCommentsWritable(dev.Project, subject); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		changes["comments"] = *req.Comments
 	}
 	if req.Subject != nil {
@@ -262,18 +423,83 @@ func UpdateDevice(c *gin.Context) {
 	if req.WillEnergizedAt != nil {
 		changes["will_energized_at"] = *req.WillEnergizedAt
 	}
+	if req.SourceRole != nil {
+		if err := validateSourceRole(*req.SourceRole); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		changes["source_role"] = *req.SourceRole
+	}
+	if req.RectPX != nil {
+		changes["rect_px"] = pq.Int64Array(*req.RectPX)
+	}
+	if req.PolygonPointsPX != nil {
+		changes["polygon_points_px"] = *req.PolygonPointsPX
+	}
+	if req.ShortSegmentsPX != nil {
+		changes["short_segments_px"] = *req.ShortSegmentsPX
+	}
+	// 传了属性，或者改了 subject 且已有属性时，按（新）subject 的定义重新校验
+	if req.Attributes != nil || (req.Subject != nil && len(dev.Attributes) > 0) {
+		merged := map[string]any{}
+		for k, v := range dev.Attributes {
+			merged[k] = v
+		}
+		for k, v := range req.Attributes {
+			merged[k] = v
+		}
+		subject := dev.Subject
+		if req.Subject != nil {
+			subject = *req.Subject
+		}
+		schemas, err := loadAttributeSchemas(dev.Project)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		attrs, err := schemas.Validate(subject, merged)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		changes["attributes"] = attrs
+	}
 
 	if len(changes) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
 		return
 	}
 
-	if err := db.GetDB().Model(&dev).Updates(changes).Error; err != nil {
+	err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if c.GetHeader("If-Match") == "" {
+			if err := tx.Model(&dev).Updates(changes).Error; err != nil {
+				return err
+			}
+		} else {
+			// 带了 If-Match 时把版本放进条件里，检查之后被别人改了也不会覆盖
+			res := tx.Model(&dev).Where("version = ?", dev.Version).Updates(changes)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return errDeviceChanged
+			}
+		}
+		if overrideAudit != nil {
+			return tx.Create(overrideAudit).Error
+		}
+		return nil
+	})
+	if errors.Is(err, errDeviceChanged) {
+		devicePreconditionFailed(c, id)
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if dev.Subject == "panel board" || dev.Subject == "Breaker" || dev.Subject == "Bus Breaker" || dev.Subject == "transformer" {
+	if cat, err := loadSubjectCatalog(dev.Project); err == nil && cat.Energizable(dev.Subject) {
 		if req.Energized != nil {
 			_ = setConnectedPolylinesEnergized([]string{id}, *req.Energized)
 
@@ -284,24 +510,98 @@ func UpdateDevice(c *gin.Context) {
 	}
 
 	if err := db.GetDB().First(&dev, "id = ?", id).Error; err == nil {
+		upn, _ := requestUser(c)
+		publishEvent(dev.Project, models.EventDeviceUpdated, dev.ID, dev, upn)
+		c.Header("ETag", deviceETag(&dev))
 		c.JSON(http.StatusOK, dev)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+// geometryError 几何校验失败统一返回 400，带上出错字段方便前端定位
+func geometryError(c *gin.Context, err error) {
+	var ge *models.GeometryError
+	if errors.As(err, &ge) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ge.Error(), "field": ge.Field})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// deviceETag 设备的 ETag，就是版本号
+func deviceETag(dev *models.Device) string {
+	return fmt.Sprintf("%q", strconv.FormatInt(dev.Version, 10))
+}
+
+// deviceIfMatch 检查 If-Match。没带这个头时不检查（老客户端照旧最后写入生效）；
+// 不匹配时已经写好 412 和设备当前状态，返回 false
+func deviceIfMatch(c *gin.Context, dev *models.Device) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+	etag := deviceETag(dev)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	devicePreconditionFailed(c, dev.ID)
+	return false
+}
+
+// devicePreconditionFailed 412，带上设备当前状态和 ETag，前端据此合并后重试
+func devicePreconditionFailed(c *gin.Context, id string) {
+	var cur models.Device
+	if err := db.GetDB().First(&cur, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "device not found"})
+		return
+	}
+	c.Header("ETag", deviceETag(&cur))
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": errDeviceChanged.Error(), "current": cur})
+}
+
+var errDeviceChanged = errors.New("device was changed by another request, reload and retry")
+
 // DELETE /api/v1/devices/:id
+// 同样支持 If-Match，版本不一致时 412
 func DeleteDevice(c *gin.Context) {
 	id := c.Param("id")
-	if err := db.GetDB().Delete(&models.Device{}, "id = ?", id).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var dev models.Device
+	found := db.GetDB().Select("id", "project", "version").First(&dev, "id = ?", id).Error == nil
+	q := db.GetDB().Where("id = ?", id)
+	if c.GetHeader("If-Match") != "" {
+		if !found {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "device not found"})
+			return
+		}
+		if !deviceIfMatch(c, &dev) {
+			return
+		}
+		q = q.Where("version = ?", dev.Version)
+	}
+	res := q.Delete(&models.Device{})
+	if res.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if found && res.RowsAffected == 0 && c.GetHeader("If-Match") != "" {
+		devicePreconditionFailed(c, id)
 		return
 	}
+	if found {
+		upn, _ := requestUser(c)
+		publishEvent(dev.Project, models.EventDeviceDeleted, id, gin.H{"id": id}, upn)
+	}
 	c.Status(http.StatusNoContent)
 }
 
 // POST /api/v1/devices/import
 // 请求体为 JSON 数组（即你给的那段）
+// 导入在后台任务里分批执行，立即返回 202 和任务信息，
+// 进度通过 GET /api/v1/import-jobs/:id 查询
 func ImportDevices(c *gin.Context) {
 	var arr []models.Device
 	if err := c.ShouldBindJSON(&arr); err != nil {
@@ -312,161 +612,41 @@ func ImportDevices(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "empty array"})
 		return
 	}
-	tx := db.GetDB().Clauses(
-		clause.OnConflict{
-			Columns:   []clause.Column{{Name: "id"}},
-			DoUpdates: clause.AssignmentColumns([]string{"subject", "project", "file_page", "rect_px", "polygon_points_px", "short_segments_px", "text", "comments", "energized", "energized_today", "will_energized_at", "from", "to", "computed_from", "computed_to", "updated_at"}),
-		},
-	).Create(&arr)
 
-	// 由于 gorm 不直接提供便捷 Upsert 的公用 Clause，另一种更清晰写法是用 gorm.io/gorm/clause
-	// 这里保持简洁；如需更严谨，可改为 clause.OnConflict{...}
-
-	if tx.Error != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": tx.Error.Error()})
+	job, err := startImportJob(arr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var energizedPanels []string
-	var deEnergizedPanels []string
-	var energizedTodayPanels []string
-	var deEnergizedTodayPanels []string
-	for _, dev := range arr {
-		if dev.Subject == "panel board" {
-			if dev.Energized {
-				energizedPanels = append(energizedPanels, dev.ID)
-			} else {
-				deEnergizedPanels = append(deEnergizedPanels, dev.ID)
-			}
-			if dev.EnergizedToday {
-				energizedTodayPanels = append(energizedTodayPanels, dev.ID)
-			} else {
-				deEnergizedTodayPanels = append(deEnergizedTodayPanels, dev.ID)
-			}
-		}
-	}
-	_ = setConnectedPolylinesEnergized(energizedPanels, true)
-	_ = setConnectedPolylinesEnergized(deEnergizedPanels, false)
-
-	_ = setConnectedPolylinesEnergizedToday(energizedTodayPanels, true)
-	_ = setConnectedPolylinesEnergizedToday(deEnergizedTodayPanels, false)
-	c.JSON(http.StatusCreated, gin.H{"count": len(arr)})
+	c.Header("Location", fmt.Sprintf("/api/v1/import-jobs/%d", job.ID))
+	c.JSON(http.StatusAccepted, gin.H{"count": len(arr), "job": job})
 }
 
 // 通用函数：把 panel 的某个布尔字段（比如 energized / energized_today）
-// 传播到相关 PolyLine 和 Bus 上
-//
-// 新逻辑：
-// 1）panel 状态改变时，先把以该 panel 为终点的 PolyLine 的同名字段更新为 value
-// 2）找到所有 “from = Bus、to = 这些 panel” 的 PolyLine，得到受影响的 Bus 列表
-// 3）对每个受影响的 Bus：
-//   - 找出所有 from = 该 Bus 的 PolyLine（即 bus → panel 的所有连线），拿到所有下游 panel
-//   - 只要这些 panel 中有任意一个 field = true，则该 Bus 的 field = true
-//     只有当所有这些 panel 的 field = false 时，Bus 的 field = false
+// 传播到相关 PolyLine 和 Bus 上。规则见 powerState.propagate，
+// 这里按项目读出快照、在内存里算好变化再写回，和模拟接口共用同一套逻辑
 //
-//
-// 注意：这里 Bus 的值不再简单等于当前这个 panel 传进来的 value，而是根据
-// 该 Bus 所有下游 panel 的状态聚合出来的。
-
+// 上锁挂牌：被锁住的支路（上锁设备、它的下游以及相连的 PolyLine）不会被置为 true。
 func propagatePanelBoolToBusAndPolylines(field string, panelIDs []string, value bool) error {
 	if len(panelIDs) == 0 {
 		return nil
 	}
 
-	dbx := db.GetDB()
-
-	updateMap := map[string]any{
-		field: value,
-	}
-
-	// 所有“母线类”设备：Bus & Bus Duct
-	BUS_SUBJECTS := []string{"Bus", "Bus Duct"}
-
-	// 1️⃣ 更新：所有以 panel 为终点的 PolyLine（只改这些 panel 自己的线）
-	if err := dbx.Model(&models.Device{}).
-		Where("subject = ? AND \"to\" IN ?", "PolyLine", panelIDs).
-		Updates(updateMap).Error; err != nil {
-		return err
-	}
-
-	// 2️⃣ 查出所有 Bus / Bus Duct 的 id
-	var allBusIDs []string
-	if err := dbx.Model(&models.Device{}).
-		Where("subject IN ?", BUS_SUBJECTS).
-		Pluck("id", &allBusIDs).Error; err != nil {
-		return err
-	}
-	if len(allBusIDs) == 0 {
-		return nil
-	}
-
-	// 3️⃣ 查出：哪些 Bus / Bus Duct 通过 polyline（from = Bus, to = 这些 panel）连到了本次变更的 panel
-	var affectedBusIDs []string
-	if err := dbx.Model(&models.Device{}).
-		Where("subject = ? AND \"from\" IN ? AND \"to\" IN ?", "PolyLine", allBusIDs, panelIDs).
-		Pluck("\"from\"", &affectedBusIDs).Error; err != nil {
+	var projects []string
+	if err := db.GetDB().Model(&models.Device{}).Where("id IN ?", panelIDs).Distinct().Pluck("project", &projects).Error; err != nil {
 		return err
 	}
-	if len(affectedBusIDs) == 0 {
-		return nil
-	}
-
-	// 去重 Bus IDs
-	busSet := make(map[string]struct{})
-	var uniqueBusIDs []string
-	for _, id := range affectedBusIDs {
-		if _, ok := busSet[id]; !ok {
-			busSet[id] = struct{}{}
-			uniqueBusIDs = append(uniqueBusIDs, id)
-		}
-	}
-
-	// 4️⃣ 对每个受影响的 Bus / Bus Duct，根据其所有下游 panel 聚合状态
-	for _, busID := range uniqueBusIDs {
-		// 找出该 Bus / Bus Duct 的所有下游 panel（from = busID）
-		var downPanelIDs []string
-		if err := dbx.Model(&models.Device{}).
-			Where("subject = ? AND \"from\" = ?", "PolyLine", busID).
-			Pluck("\"to\"", &downPanelIDs).Error; err != nil {
-			return err
-		}
-		if len(downPanelIDs) == 0 {
-			continue
-		}
-
-		// 只要有一个 panel 的 field = true，则母线 = true；否则 false
-		var trueCount int64
-		if err := dbx.Model(&models.Device{}).
-			Where("id IN ?", downPanelIDs).
-			Where(fmt.Sprintf("%s = ?", field), true).
-			Count(&trueCount).Error; err != nil {
+	for _, p := range projects {
+		s, err := loadPowerState(p)
+		if err != nil {
 			return err
 		}
-
-		busValue := trueCount > 0
-		busUpdate := map[string]any{
-			field: busValue,
-		}
-
-		// 4-1️⃣ 更新 Bus / Bus Duct 自己
-		if err := dbx.Model(&models.Device{}).
-			Where("subject IN ? AND id = ?", BUS_SUBJECTS, busID).
-			Updates(busUpdate).Error; err != nil {
+		s.propagate(field, panelIDs, value)
+		if err := s.save(); err != nil {
 			return err
 		}
-
-		// 4-2️⃣ 再更新：所有「以这个 Bus / Bus Duct 为终点」的 PolyLine
-		//      比如上游馈线（to = busID），让它跟 bus 的状态保持一致
-		if err := dbx.Model(&models.Device{}).
-			Where("subject = ? AND \"to\" = ?", "PolyLine", busID).
-			Updates(busUpdate).Error; err != nil {
-			return err
-		}
-
-		// ❌ 不去动 from = busID 的 polyline（bus → panels），
-		//    避免你之前说的“一个 panel 变化，把所有出线全点亮”的问题
 	}
-
 	return nil
 }
 
@@ -512,6 +692,11 @@ func SearchDevices(c *gin.Context) {
 	if q.FilePage != 0 {
 		d = d.Where("file_page = ?", q.FilePage)
 	}
+	d, err := applyAttributeFilters(d, c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// 统计总数
 	var total int64
@@ -527,6 +712,10 @@ func SearchDevices(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if err := fillChecklistProgress(items); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"query": gin.H{
@@ -560,21 +749,33 @@ func UploadDeviceFile(c *gin.Context) {
 		fileType = "other"
 	}
 
-	// 3. 取文件
+	// 3. 保存文件并写入数据库
+	record, ok := saveDeviceFile(c, &dev, fileType, nil)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusCreated, record)
+}
+
+// saveDeviceFile 把表单字段 file 保存到设备的上传目录并写入 device_files；
+// 出错时已经写好响应，返回 ok=false
+func saveDeviceFile(c *gin.Context, dev *models.Device, fileType string, issueID *uint) (*models.DeviceFile, bool) {
+	// 取文件
 	fileHeader, err := c.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
-		return
+		return nil, false
 	}
 
-	// 4. 构造保存路径
+	// 构造保存路径
 	uploadRoot := config.UploadDir()
 	projectDir := filepath.Join(uploadRoot, dev.Project)
 	deviceDir := filepath.Join(projectDir, fmt.Sprintf("%s_%s", dev.ID, dev.Text))
 
 	if err := os.MkdirAll(deviceDir, 0755); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot create upload dir"})
-		return
+		return nil, false
 	}
 
 	// 为了防止重名，在前面加一个时间戳
@@ -583,31 +784,34 @@ func UploadDeviceFile(c *gin.Context) {
 
 	dstPath := filepath.Join(deviceDir, safeName)
 
-	// 5. 保存到本地
+	// 保存到本地
 	if err := c.SaveUploadedFile(fileHeader, dstPath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "save file failed"})
-		return
+		return nil, false
 	}
 
-	// 6. 写入数据库
+	// 写入数据库
 	record := models.DeviceFile{
-		DeviceID: deviceID,
+		DeviceID: dev.ID,
 		Project:  dev.Project,
 		FileType: fileType,
 		FileName: fileHeader.Filename,
 		FilePath: dstPath,
 		FileSize: fileHeader.Size,
 		MimeType: fileHeader.Header.Get("Content-Type"),
+		IssueID:  issueID,
 	}
 
 	if err := db.GetDB().Create(&record).Error; err != nil {
 		// 数据库失败的话，把刚刚保存的文件删掉，避免垃圾文件
 		_ = os.Remove(dstPath)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db save failed"})
-		return
+		return nil, false
 	}
 
-	c.JSON(http.StatusCreated, record)
+	upn, _ := requestUser(c)
+	publishEvent(dev.Project, models.EventFileUploaded, dev.ID, record, upn)
+	return &record, true
 }
 
 // GET /api/v1/devices/:id/files
@@ -662,5 +866,8 @@ func DeleteDeviceFile(c *gin.Context) {
 
 	_ = os.Remove(f.FilePath) // 文件删不掉也不影响接口返回
 
+	upn, _ := requestUser(c)
+	publishEvent(f.Project, models.EventFileDeleted, f.DeviceID, gin.H{"id": f.ID, "device_id": f.DeviceID}, upn)
+
 	c.Status(http.StatusNoContent)
 }
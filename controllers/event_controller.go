@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	eventPollInterval = 2 * time.Second  // 没有本进程的通知时多久查一次库（多实例部署时靠它）
+	eventHeartbeat    = 15 * time.Second // 空闲时发注释行，防止代理断开连接
+	eventBatchSize    = 500
+)
+
+// eventHub 本进程内的订阅者，按项目分组。有新事件时只是唤醒订阅者去查库，
+// 事件本身一律从 change_events 表读，这样补发和实时推送走同一条路径
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]struct{}
+}
+
+var hub = &eventHub{subs: map[string]map[chan struct{}]struct{}{}}
+
+func (h *eventHub) subscribe(project string) chan struct{} {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[project] == nil {
+		h.subs[project] = map[chan struct{}]struct{}{}
+	}
+	h.subs[project][ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(project string, ch chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs[project], ch)
+	if len(h.subs[project]) == 0 {
+		delete(h.subs, project)
+	}
+}
+
+func (h *eventHub) notify(project string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[project] {
+		select {
+		case ch <- struct{}{}:
+		default: // 已经有一个没处理的通知了
+		}
+	}
+}
+
+// publishEvent 记下一条变更事件并唤醒订阅者。事件只是通知，写失败不影响业务请求
+func publishEvent(project, typ, entityID string, data any, actor string) {
+	if project == "" {
+		return
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("publish %s event: %v", typ, err)
+		return
+	}
+	ev := models.ChangeEvent{Project: project, Type: typ, EntityID: entityID, Data: datatypes.JSON(raw), Actor: actor}
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		// 同一项目的事件串行写入：拿到锁之后才分配 id，提交时才放锁。
+		// 这样 id 小的一定先提交，推送按 id > last 读时不会跳过还没提交的事件
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "change_events:"+project).Error; err != nil {
+			return err
+		}
+		return tx.Create(&ev).Error
+	})
+	if err != nil {
+		log.Printf("publish %s event: %v", typ, err)
+		return
+	}
+	hub.notify(project)
+}
+
+// publishDeviceEvent 重新读出设备再发事件，推送的是写库后的完整状态
+func publishDeviceEvent(c *gin.Context, typ, id string) {
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", id).Error; err != nil {
+		return
+	}
+	upn, _ := requestUser(c)
+	publishEvent(dev.Project, typ, dev.ID, dev, upn)
+}
+
+// GET /api/v1/projects/:project/events
+// Server-Sent Events：推送项目里设备的新增 / 修改 / 删除、传播结果、文件和评论。
+// 断线重连时浏览器会带上 Last-Event-ID，从那之后补发；也可以用 ?last_event_id= 指定。
+// 都没有时只推送连接之后的新事件
+func StreamProjectEvents(c *gin.Context) {
+	project := c.Param("project")
+
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	var last uint64
+	if lastID != "" {
+		n, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid last event id"})
+			return
+		}
+		last = n
+	} else if err := db.GetDB().Model(&models.ChangeEvent{}).
+		Where("project = ?", project).
+		Select("COALESCE(MAX(id), 0)").
+		Scan(&last).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	wake := hub.subscribe(project)
+	defer hub.unsubscribe(project, wake)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // nginx 不要缓冲
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: 3000\n\n")
+	c.Writer.Flush()
+
+	poll := time.NewTicker(eventPollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	ctx := c.Request.Context()
+	for {
+		// 一次最多读一批，读满了说明还有，马上接着读
+		for {
+			var events []models.ChangeEvent
+			if err := db.GetDB().
+				Where("project = ? AND id > ?", project, last).
+				Order("id").
+				Limit(eventBatchSize).
+				Find(&events).Error; err != nil {
+				log.Printf("event stream %s: %v", project, err)
+				break
+			}
+			for _, ev := range events {
+				payload, _ := json.Marshal(ev)
+				fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, payload)
+				last = ev.ID
+			}
+			if len(events) > 0 {
+				c.Writer.Flush()
+				heartbeat.Reset(eventHeartbeat)
+			}
+			if len(events) < eventBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-poll.C:
+		case <-heartbeat.C:
+			fmt.Fprintf(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
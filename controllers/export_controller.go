@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// 导出时固定放在前面的文件类型列，其余类型按字母顺序追加
+var exportFileTypes = []string{"panel_schedule", "test_report", "other"}
+
+// GET /api/v1/projects/:project/equipments/export?format=csv|xlsx
+// subject 过滤和 GetEquipmentsByProject 一致
+func ExportEquipmentsByProject(c *gin.Context) {
+	project := c.Param("project")
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or xlsx"})
+		return
+	}
+
+	header, rows, err := equipmentExportRows(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("%s_equipments_%s.%s", project, time.Now().Format("20060102"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		_ = w.Write(header)
+		for _, row := range rows {
+			rec := make([]string, len(row))
+			for i, v := range row {
+				rec[i] = exportCellString(v)
+			}
+			_ = w.Write(rec)
+		}
+		w.Flush()
+		return
+	}
+
+	f, err := equipmentWorkbook(header, rows)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	_ = f.Write(c.Writer)
+}
+
+// equipmentExportRows 查出项目的 equipment，拼成表头 + 数据行
+func equipmentExportRows(project string) ([]string, [][]any, error) {
+	dbx := db.GetDB()
+
+	schemas, err := loadAttributeSchemas(project)
+	if err != nil {
+		return nil, nil, err
+	}
+	cat := schemas.catalog
+	attrKeys := schemas.Keys(models.SubjectEquipment)
+
+	var devices []models.Device
+	if err := dbx.
+		Where("project = ? AND LOWER(subject) IN ?", project, cat.MatchKeys(models.SubjectEquipment)).
+		Order("subject, text").
+		Find(&devices).Error; err != nil {
+		return nil, nil, err
+	}
+
+	locs, err := locateDevices(project, devices)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 按 device + file_type 统计文件数
+	type fileCountRow struct {
+		DeviceID string
+		FileType string
+		Count    int64
+	}
+	var fileRows []fileCountRow
+	if len(devices) > 0 {
+		ids := make([]string, 0, len(devices))
+		for _, d := range devices {
+			ids = append(ids, d.ID)
+		}
+		if err := dbx.Model(&models.DeviceFile{}).
+			Select("device_id, file_type, COUNT(*) AS count").
+			Where("device_id IN ?", ids).
+			Group("device_id, file_type").
+			Scan(&fileRows).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	counts := map[string]map[string]int64{}
+	fileTypes := append([]string{}, exportFileTypes...)
+	known := map[string]bool{}
+	for _, t := range fileTypes {
+		known[t] = true
+	}
+	var extra []string
+	for _, r := range fileRows {
+		if counts[r.DeviceID] == nil {
+			counts[r.DeviceID] = map[string]int64{}
+		}
+		counts[r.DeviceID][r.FileType] += r.Count
+		if !known[r.FileType] {
+			known[r.FileType] = true
+			extra = append(extra, r.FileType)
+		}
+	}
+	sort.Strings(extra)
+	fileTypes = append(fileTypes, extra...)
+
+	header := []string{
+		"id", "text", "subject", "file_page", "room", "level",
+		"computed_from", "computed_to", "energized", "energized_today", "will_energized_at",
+		"file_count",
+	}
+	for _, t := range fileTypes {
+		header = append(header, "files_"+t)
+	}
+	for _, k := range attrKeys {
+		header = append(header, "attr_"+k)
+	}
+
+	rows := make([][]any, 0, len(devices))
+	for _, d := range devices {
+		var willAt any = ""
+		if d.WillEnergizedAt != nil {
+			willAt = *d.WillEnergizedAt
+		}
+		var total int64
+		for _, n := range counts[d.ID] {
+			total += n
+		}
+		loc := locs[d.ID]
+
+		row := []any{
+			d.ID, d.Text, d.Subject, d.FilePage, loc.Room, loc.Level,
+			d.ComputedFrom, d.ComputedTo, d.Energized, d.EnergizedToday, willAt,
+			total,
+		}
+		for _, t := range fileTypes {
+			row = append(row, counts[d.ID][t])
+		}
+		for _, k := range attrKeys {
+			v, ok := d.Attributes[k]
+			if !ok {
+				row = append(row, "")
+				continue
+			}
+			if f, isNum := attributeNumber(v); isNum {
+				row = append(row, f)
+				continue
+			}
+			row = append(row, v)
+		}
+		rows = append(rows, row)
+	}
+	return header, rows, nil
+}
+
+func exportCellString(v any) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		return x.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+func equipmentWorkbook(header []string, rows [][]any) (*excelize.File, error) {
+	const sheet = "Equipments"
+
+	f := excelize.NewFile()
+	if err := f.SetSheetName("Sheet1", sheet); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 22}) // m/d/yy h:mm
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	headerRow := make([]any, len(header))
+	for i, h := range header {
+		headerRow[i] = h
+	}
+	if err := f.SetSheetRow(sheet, "A1", &headerRow); err != nil {
+		f.Close()
+		return nil, err
+	}
+	lastCol, _ := excelize.ColumnNumberToName(len(header))
+	_ = f.SetCellStyle(sheet, "A1", lastCol+"1", bold)
+
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+			f.Close()
+			return nil, err
+		}
+		for j, v := range row {
+			if _, ok := v.(time.Time); ok {
+				cell, _ := excelize.CoordinatesToCellName(j+1, i+2)
+				_ = f.SetCellStyle(sheet, cell, cell, dateStyle)
+			}
+		}
+	}
+
+	// 冻结表头 + 自动筛选，方便直接在 Excel 里过滤
+	_ = f.SetPanes(sheet, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+	lastCell, _ := excelize.CoordinatesToCellName(len(header), len(rows)+1)
+	_ = f.AutoFilter(sheet, "A1:"+lastCell, nil)
+
+	return f, nil
+}
@@ -0,0 +1,403 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GeoJSON（RFC 7946）的结构，坐标用的是图纸的像素坐标系而不是经纬度：
+// x 向右、y 向下，原点在图纸左上角。
+type geoFeatureCollection struct {
+	Type            string       `json:"type"`
+	Project         string       `json:"project,omitempty"`
+	FilePage        int          `json:"file_page,omitempty"`
+	CoordinateSpace string       `json:"coordinate_space,omitempty"` // 固定 "pixel"
+	Features        []geoFeature `json:"features"`
+}
+
+type geoFeature struct {
+	Type       string         `json:"type"`
+	ID         any            `json:"id,omitempty"`
+	BBox       []float64      `json:"bbox,omitempty"`
+	Geometry   *geoGeometry   `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type geoGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// geoImportError 导入时单个 feature 的错误，Index 是 features 数组下标
+type geoImportError struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// GET /api/v1/projects/:project/pages/:page/geojson
+func ExportPageGeoJSON(c *gin.Context) {
+	project := c.Param("project")
+	page, err := strconv.Atoi(c.Param("page"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		return
+	}
+
+	var devices []models.Device
+	if err := db.GetDB().
+		Where("project = ? AND file_page = ?", project, page).
+		Order("id").
+		Find(&devices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	fc := geoFeatureCollection{
+		Type:            "FeatureCollection",
+		Project:         project,
+		FilePage:        page,
+		CoordinateSpace: "pixel",
+		Features:        make([]geoFeature, 0, len(devices)),
+	}
+	for _, d := range devices {
+		f, ok := deviceToFeature(d)
+		if !ok {
+			continue
+		}
+		fc.Features = append(fc.Features, f)
+	}
+
+	b, err := json.Marshal(fc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "application/geo+json", b)
+}
+
+// POST /api/v1/projects/:project/pages/:page/geojson
+// 请求体是 FeatureCollection。按 feature id upsert 设备的几何和描述字段
+// （subject / text / comments / from / to）和属性；energized 等状态字段只导出不导入，
+// 状态只能走设备接口修改，保证传播逻辑一致。
+// 任何一个 feature 有问题整个请求返回 400，不写库；feature id 已经是别的项目或页的设备时返回 409。
+func ImportPageGeoJSON(c *gin.Context) {
+	project := c.Param("project")
+	page, err := strconv.Atoi(c.Param("page"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		return
+	}
+
+	if ok, err := projectExists(project); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+
+	var fc geoFeatureCollection
+	if err := c.ShouldBindJSON(&fc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if fc.Type != "FeatureCollection" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be FeatureCollection"})
+		return
+	}
+	if len(fc.Features) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "empty feature collection"})
+		return
+	}
+
+	bounds := pageBounds(project, page)
+
+	type parsed struct {
+		dev     models.Device
+		columns []string
+	}
+	items := make([]parsed, 0, len(fc.Features))
+	var errs []geoImportError
+	for i, f := range fc.Features {
+		dev, cols, err := featureToDevice(f, bounds)
+		if err != nil {
+			errs = append(errs, geoImportError{Index: i, ID: dev.ID, Error: err.Error()})
+			continue
+		}
+		dev.Project = project
+		dev.FilePage = page
+		items = append(items, parsed{dev: dev, columns: cols})
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid features", "errors": errs})
+		return
+	}
+
+	ids := make([]string, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.dev.ID)
+	}
+	// 已删除的设备也算：同一个 id 不能被导入成别的项目 / 页的设备
+	var existing []models.Device
+	if err := db.GetDB().Unscoped().
+		Select("id", "project", "file_page", "subject", "comments").
+		Where("id IN ?", ids).
+		Find(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	existed := make(map[string]models.Device, len(existing))
+	var elsewhere []string
+	for _, d := range existing {
+		existed[d.ID] = d
+		if d.Project != project || d.FilePage != page {
+			elsewhere = append(elsewhere, d.ID)
+		}
+	}
+	if len(elsewhere) > 0 {
+		sort.Strings(elsewhere)
+		c.JSON(http.StatusConflict, gin.H{"error": "feature ids belong to devices on another project or page", "ids": elsewhere})
+		return
+	}
+
+	// 属性和 comments 按设备（新的或已有的）subject 校验
+	schemas, err := loadAttributeSchemas(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range items {
+		dev := &items[i].dev
+		subject := dev.Subject
+		if !slices.Contains(items[i].columns, "subject") {
+			subject = existed[dev.ID].Subject
+		}
+		// 非建筑类的备注已经改成评论线程；导出时带出来的旧值原样导回可以，改了就报错
+		if slices.Contains(items[i].columns, "comments") && schemas.catalog.Category(subject) != models.SubjectArchitectural {
+			if dev.Comments != existed[dev.ID].Comments {
+				errs = append(errs, geoImportError{Index: i, ID: dev.ID, Error: errCommentsMoved.Error()})
+				continue
+			}
+			items[i].columns = slices.DeleteFunc(items[i].columns, func(col string) bool { return col == "comments" })
+		}
+		if !slices.Contains(items[i].columns, "attributes") {
+			continue
+		}
+		attrs, err := schemas.Validate(subject, dev.Attributes)
+		if err != nil {
+			errs = append(errs, geoImportError{Index: i, ID: dev.ID, Error: err.Error()})
+			continue
+		}
+		dev.Attributes = attrs
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid features", "errors": errs})
+		return
+	}
+
+	created, updated := 0, 0
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		for _, it := range items {
+			dev := it.dev
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "id"}},
+				DoUpdates: deviceUpsertSet(it.columns),
+			}).Create(&dev).Error; err != nil {
+				return fmt.Errorf("feature %s: %w", dev.ID, err)
+			}
+			if _, ok := existed[dev.ID]; ok {
+				updated++
+			} else {
+				created++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	upn, _ := requestUser(c)
+	publishEvent(project, models.EventDevicesImported, "", gin.H{"file_page": page, "ids": ids}, upn)
+
+	c.JSON(http.StatusOK, gin.H{
+		"project":   project,
+		"file_page": page,
+		"created":   created,
+		"updated":   updated,
+	})
+}
+
+// deviceToFeature PolyLine 导出成 LineString，其余标注导出成矩形 Polygon；
+// bbox 就是 rect_px
+func deviceToFeature(d models.Device) (geoFeature, bool) {
+	if len(d.RectPX) != 4 {
+		return geoFeature{}, false
+	}
+	x1, y1, x2, y2 := float64(d.RectPX[0]), float64(d.RectPX[1]), float64(d.RectPX[2]), float64(d.RectPX[3])
+
+	var geom geoGeometry
+	if len(d.PolygonPointsPX) >= 2 {
+		coords, _ := json.Marshal(d.PolygonPointsPX)
+		geom = geoGeometry{Type: "LineString", Coordinates: coords}
+	} else {
+		coords, _ := json.Marshal([][][2]float64{{{x1, y1}, {x2, y1}, {x2, y2}, {x1, y2}, {x1, y1}}})
+		geom = geoGeometry{Type: "Polygon", Coordinates: coords}
+	}
+
+	props := map[string]any{
+		"id":              d.ID,
+		"subject":         d.Subject,
+		"text":            d.Text,
+		"comments":        d.Comments,
+		"energized":       d.Energized,
+		"energized_today": d.EnergizedToday,
+	}
+	if d.WillEnergizedAt != nil {
+		props["will_energized_at"] = d.WillEnergizedAt.Format(time.RFC3339)
+	}
+	for k, v := range map[string]string{"from": d.From, "to": d.To, "computed_from": d.ComputedFrom, "computed_to": d.ComputedTo} {
+		if v != "" {
+			props[k] = v
+		}
+	}
+	if len(d.ShortSegmentsPX) > 0 {
+		props["short_segments_px"] = d.ShortSegmentsPX
+	}
+	if len(d.Attributes) > 0 {
+		props["attributes"] = d.Attributes
+	}
+
+	return geoFeature{
+		Type:       "Feature",
+		ID:         d.ID,
+		BBox:       []float64{x1, y1, x2, y2},
+		Geometry:   &geom,
+		Properties: props,
+	}, true
+}
+
+// featureToDevice 把 feature 转回 Device，同时返回冲突时需要覆盖的列
+func featureToDevice(f geoFeature, bounds *models.PageBounds) (models.Device, []string, error) {
+	var dev models.Device
+
+	switch v := f.ID.(type) {
+	case string:
+		dev.ID = v
+	case nil:
+	default:
+		dev.ID = fmt.Sprint(v)
+	}
+	if id, ok := f.Properties["id"].(string); ok && id != "" {
+		dev.ID = id
+	}
+	if dev.ID == "" {
+		return dev, nil, errors.New("feature id is required")
+	}
+	if f.Type != "Feature" {
+		return dev, nil, errors.New("type must be Feature")
+	}
+	if f.Geometry == nil {
+		return dev, nil, errors.New("geometry is required")
+	}
+
+	// project / file_page 只在新建时写入，已有设备不会被移到别的页
+	columns := []string{"rect_px", "polygon_points_px", "updated_at"}
+
+	var minX, minY, maxX, maxY = math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	extend := func(pts []models.PointPX) {
+		for _, p := range pts {
+			minX, minY = math.Min(minX, p[0]), math.Min(minY, p[1])
+			maxX, maxY = math.Max(maxX, p[0]), math.Max(maxY, p[1])
+		}
+	}
+
+	switch f.Geometry.Type {
+	case "LineString":
+		var pts models.PolylinePX
+		if err := json.Unmarshal(f.Geometry.Coordinates, &pts); err != nil {
+			return dev, nil, fmt.Errorf("invalid LineString coordinates: %v", err)
+		}
+		dev.PolygonPointsPX = pts
+		extend(pts)
+	case "Polygon":
+		var rings [][]models.PointPX
+		if err := json.Unmarshal(f.Geometry.Coordinates, &rings); err != nil {
+			return dev, nil, fmt.Errorf("invalid Polygon coordinates: %v", err)
+		}
+		if len(rings) == 0 || len(rings[0]) < 4 {
+			return dev, nil, errors.New("Polygon needs a closed outer ring")
+		}
+		extend(rings[0])
+	default:
+		return dev, nil, fmt.Errorf("unsupported geometry type %q", f.Geometry.Type)
+	}
+
+	// 优先用 bbox（和 rect_px 一一对应），没有就用几何外包框
+	if len(f.BBox) == 4 {
+		minX, minY, maxX, maxY = f.BBox[0], f.BBox[1], f.BBox[2], f.BBox[3]
+	}
+	dev.RectPX = []int64{int64(math.Round(minX)), int64(math.Round(minY)), int64(math.Round(maxX)), int64(math.Round(maxY))}
+
+	for _, key := range []string{"subject", "text", "comments", "from", "to"} {
+		v, ok := f.Properties[key]
+		if !ok {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return dev, nil, fmt.Errorf("property %s must be a string", key)
+		}
+		switch key {
+		case "subject":
+			dev.Subject = s
+		case "text":
+			dev.Text = s
+		case "comments":
+			dev.Comments = s
+		case "from":
+			dev.From = s
+		case "to":
+			dev.To = s
+		}
+		columns = append(columns, key)
+	}
+	if v, ok := f.Properties["short_segments_px"]; ok {
+		b, _ := json.Marshal(v)
+		var segs models.SegmentsPX
+		if err := json.Unmarshal(b, &segs); err != nil {
+			return dev, nil, fmt.Errorf("invalid short_segments_px: %v", err)
+		}
+		dev.ShortSegmentsPX = segs
+		columns = append(columns, "short_segments_px")
+	}
+
+	// 属性在调用方按 subject 校验
+	if v, ok := f.Properties["attributes"]; ok && v != nil {
+		attrs, ok := v.(map[string]any)
+		if !ok {
+			return dev, nil, errors.New("property attributes must be an object")
+		}
+		dev.Attributes = attrs
+		columns = append(columns, "attributes")
+	}
+
+	if err := dev.ValidateGeometry(bounds); err != nil {
+		return dev, nil, err
+	}
+	return dev, columns, nil
+}
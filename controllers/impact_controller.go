@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// impactItem 停电影响里的一个设备
+type impactItem struct {
+	ID        string `json:"id"`
+	Text      string `json:"text"`
+	Subject   string `json:"subject"`
+	FilePage  int    `json:"file_page"`
+	Room      string `json:"room,omitempty"`
+	Level     string `json:"level,omitempty"`
+	Energized bool   `json:"energized"` // 当前是否送电，false 的设备本来就没电
+}
+
+// impactGroup 按楼层 / 房间 / subject 分组
+type impactGroup struct {
+	Level   string       `json:"level"`
+	Room    string       `json:"room"`
+	Subject string       `json:"subject"`
+	Count   int          `json:"count"`
+	Devices []impactItem `json:"devices"`
+}
+
+// outageImpact 设备停电后失去电源的下游，以及靠其他电源（发电机、ATS 另一路等）继续供电的下游。
+// 断开的开关是边界：它后面的设备本来就没电，也不能作为备用路径
+func outageImpact(t *topology, id string) (lost, alternate []string) {
+	// 从其他正在送电的电源出发、不经过 id 能到的节点都还有电。
+	// 没送电的发电机、上游没画出来的设备也是拓扑的根，不能算作备用电源
+	live := map[string]bool{}
+	queue := []string{}
+	for _, r := range t.Roots() {
+		if n := t.Nodes[r]; r != id && n != nil && n.Energized {
+			live[r] = true
+			queue = append(queue, r)
+		}
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, e := range t.Down[cur] {
+			if e.Open || e.To == id || live[e.To] {
+				continue
+			}
+			live[e.To] = true
+			queue = append(queue, e.To)
+		}
+	}
+
+	for _, n := range t.FedDownstream(id) {
+		if live[n] {
+			alternate = append(alternate, n)
+		} else {
+			lost = append(lost, n)
+		}
+	}
+	return lost, alternate
+}
+
+// GET /api/v1/devices/:id/impact?format=json|csv
+// 设备停电检修时，所有会失去电源的下游设备，按楼层 / 房间 / subject 分组；
+// 有其他电源（发电机、ATS 另一路）继续供电的列在 alternate 里。csv 用于给业主的停电通知
+func GetDeviceImpact(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+	t, err := loadTopology(dev.Project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if t.Nodes[dev.ID] == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device is not part of the distribution topology"})
+		return
+	}
+
+	lostIDs, altIDs := outageImpact(t, dev.ID)
+
+	nodes := make([]models.Device, 0, len(lostIDs)+len(altIDs))
+	for _, id := range append(append([]string{}, lostIDs...), altIDs...) {
+		nodes = append(nodes, *t.Nodes[id])
+	}
+	locs, err := locateDevices(dev.Project, nodes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	item := func(id string) impactItem {
+		d := t.Nodes[id]
+		loc := locs[id]
+		return impactItem{ID: d.ID, Text: d.Text, Subject: d.Subject, FilePage: d.FilePage, Room: loc.Room, Level: loc.Level, Energized: d.Energized}
+	}
+
+	lost := make([]impactItem, 0, len(lostIDs))
+	for _, id := range lostIDs {
+		lost = append(lost, item(id))
+	}
+	sort.SliceStable(lost, func(i, j int) bool {
+		a, b := lost[i], lost[j]
+		if a.Level != b.Level {
+			return a.Level < b.Level
+		}
+		if a.Room != b.Room {
+			return a.Room < b.Room
+		}
+		if a.Subject != b.Subject {
+			return a.Subject < b.Subject
+		}
+		return a.Text < b.Text
+	})
+
+	if format == "csv" {
+		filename := fmt.Sprintf("%s_impact_%s_%s.csv", dev.Project, dev.ID, time.Now().Format("20060102"))
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		_ = w.Write([]string{"level", "room", "subject", "id", "text", "file_page", "energized"})
+		for _, it := range lost {
+			_ = w.Write([]string{it.Level, it.Room, it.Subject, it.ID, it.Text, strconv.Itoa(it.FilePage), strconv.FormatBool(it.Energized)})
+		}
+		w.Flush()
+		return
+	}
+
+	var groups []*impactGroup
+	energized := 0
+	for _, it := range lost {
+		if it.Energized {
+			energized++
+		}
+		if n := len(groups); n == 0 || groups[n-1].Level != it.Level || groups[n-1].Room != it.Room || groups[n-1].Subject != it.Subject {
+			groups = append(groups, &impactGroup{Level: it.Level, Room: it.Room, Subject: it.Subject})
+		}
+		g := groups[len(groups)-1]
+		g.Count++
+		g.Devices = append(g.Devices, it)
+	}
+
+	alternate := make([]impactItem, 0, len(altIDs))
+	for _, id := range altIDs {
+		alternate = append(alternate, item(id))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":       dev.ID,
+		"device_text":     dev.Text,
+		"count":           len(lost),
+		"energized_count": energized,
+		"groups":          groups,
+		"alternate":       alternate,
+	})
+}
@@ -0,0 +1,419 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 每批 upsert / 传播的行数；15k 行的项目文件大约 30 批
+const importBatchSize = 500
+
+// 行级错误最多保留这么多条，避免一个坏文件把 errors 字段撑爆
+const maxImportRowErrors = 1000
+
+// 导入时冲突（id 相同）要覆盖的列；attributes、source_role 只在导入行带了值时覆盖（见 upsertDevices）
+var deviceUpsertColumns = []string{"subject", "project", "file_page", "rect_px", "polygon_points_px", "short_segments_px", "text", "comments", "energized", "energized_today", "will_energized_at", "from", "to", "computed_from", "computed_to", "updated_at"}
+
+// GET /api/v1/import-jobs/:id
+func GetImportJob(c *gin.Context) {
+	id := c.Param("id")
+
+	var job models.ImportJob
+	if err := db.GetDB().First(&job, "id = ?", id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "import job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// 跑任务的进程重启了就不会再有进度，不让客户端一直轮询下去
+	if (job.Status == models.ImportJobPending || job.Status == models.ImportJobRunning) && time.Since(job.UpdatedAt) > models.ImportJobStaleAfter {
+		now := time.Now()
+		res := db.GetDB().Model(&job).
+			Where("status = ? AND updated_at = ?", job.Status, job.UpdatedAt).
+			Updates(map[string]any{"status": models.ImportJobFailed, "message": models.ImportJobAbandoned, "finished_at": now})
+		if res.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+			return
+		}
+		if err := db.GetDB().First(&job, "id = ?", id).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// startImportJob 建一条 ImportJob 记录，然后在后台 goroutine 里跑导入
+func startImportJob(arr []models.Device) (*models.ImportJob, error) {
+	job := models.ImportJob{
+		Status: models.ImportJobPending,
+		Total:  len(arr),
+	}
+	if err := db.GetDB().Create(&job).Error; err != nil {
+		return nil, err
+	}
+
+	go runImportJob(job, arr)
+	return &job, nil
+}
+
+// importJobRunner 负责在导入过程中累积进度并落库
+type importJobRunner struct {
+	job      models.ImportJob
+	errors   []models.ImportRowError
+	projects map[string]bool               // 已查过的项目名 -> 是否存在
+	bounds   map[string]*models.PageBounds // "project/page" -> 页面边界
+	schemas  map[string]*attributeSchemas  // 项目 -> 属性定义
+	locked   map[string]*lockedBranches    // 项目 -> 被上锁的支路（导入开始时的状态）
+	gates    map[string]*models.EnergizationGate
+}
+
+func (r *importJobRunner) lockedBranches(project string) (*lockedBranches, error) {
+	if b, seen := r.locked[project]; seen {
+		return b, nil
+	}
+	b, err := loadLockedBranches(project)
+	if err != nil {
+		return nil, err
+	}
+	r.locked[project] = b
+	return b, nil
+}
+
+// energizeBlockers 导入行要把设备从未送电改成送电时按项目的送电许可检查，
+// 返回没满足的条件。导入不能 override，不满足的行直接报错
+func (r *importJobRunner) energizeBlockers(dev *models.Device) ([]unmetPrerequisite, error) {
+	gate, seen := r.gates[dev.Project]
+	if !seen {
+		gate = loadProjectSettings(dev.Project).EnergizationGate
+		r.gates[dev.Project] = gate
+	}
+	// 能走到这里的行，项目的属性定义（连同 subject 目录）已经加载过
+	if !gate.Enabled || !gateApplies(r.schemas[dev.Project].catalog, dev.Subject) {
+		return nil, nil
+	}
+	var cur models.Device
+	err := db.GetDB().Select("id", "energized").First(&cur, "id = ?", dev.ID).Error
+	if err == nil && cur.Energized {
+		return nil, nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return checkEnergizeReadiness(dev, gate)
+}
+
+func (r *importJobRunner) attributeSchemas(project string) (*attributeSchemas, error) {
+	if s, seen := r.schemas[project]; seen {
+		return s, nil
+	}
+	s, err := loadAttributeSchemas(project)
+	if err != nil {
+		return nil, err
+	}
+	r.schemas[project] = s
+	return s, nil
+}
+
+func (r *importJobRunner) pageBounds(project string, filePage int) *models.PageBounds {
+	key := fmt.Sprintf("%s/%d", project, filePage)
+	if b, seen := r.bounds[key]; seen {
+		return b
+	}
+	b := pageBounds(project, filePage)
+	r.bounds[key] = b
+	return b
+}
+
+func (r *importJobRunner) projectExists(name string) (bool, error) {
+	if ok, seen := r.projects[name]; seen {
+		return ok, nil
+	}
+	ok, err := projectExists(name)
+	if err != nil {
+		return false, err
+	}
+	r.projects[name] = ok
+	return ok, nil
+}
+
+func (r *importJobRunner) rowError(row int, id string, err string) {
+	r.job.Failed++
+	if len(r.errors) < maxImportRowErrors {
+		r.errors = append(r.errors, models.ImportRowError{Row: row, ID: id, Error: err})
+	}
+}
+
+func (r *importJobRunner) save() {
+	if len(r.errors) > 0 {
+		if b, err := json.Marshal(r.errors); err == nil {
+			r.job.Errors = b
+		}
+	}
+	if err := db.GetDB().Save(&r.job).Error; err != nil {
+		log.Printf("import job %d: save progress failed: %v", r.job.ID, err)
+	}
+}
+
+func (r *importJobRunner) finish(status, message string) {
+	now := time.Now()
+	r.job.Status = status
+	r.job.Message = message
+	r.job.FinishedAt = &now
+	r.save()
+}
+
+func runImportJob(job models.ImportJob, arr []models.Device) {
+	r := &importJobRunner{job: job, projects: map[string]bool{}, bounds: map[string]*models.PageBounds{}, schemas: map[string]*attributeSchemas{}, locked: map[string]*lockedBranches{}, gates: map[string]*models.EnergizationGate{}}
+
+	// 后台任务里 panic 不能把整个服务带崩，记到任务上就行
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("import job %d panicked: %v", r.job.ID, p)
+			r.finish(models.ImportJobFailed, fmt.Sprintf("internal error: %v", p))
+		}
+	}()
+
+	now := time.Now()
+	r.job.Status = models.ImportJobRunning
+	r.job.Phase = "upsert"
+	r.job.StartedAt = &now
+	r.save()
+
+	// ===== 阶段一：分批 upsert =====
+	var imported []models.Device
+	for start := 0; start < len(arr); start += importBatchSize {
+		end := min(start+importBatchSize, len(arr))
+		batch := r.upsertBatch(arr, start, end)
+		imported = append(imported, batch...)
+		r.publishImported(batch)
+		r.job.Processed = end
+		r.save()
+	}
+
+	// ===== 阶段二：分批把 panel 状态传播到 PolyLine / Bus =====
+	r.job.Phase = "propagate"
+	r.save()
+
+	var energizedPanels []string
+	var deEnergizedPanels []string
+	var energizedTodayPanels []string
+	var deEnergizedTodayPanels []string
+	for _, dev := range imported {
+		// 能进到 imported 的行，项目的属性定义（连同 subject 目录）已经加载过
+		if r.schemas[dev.Project].catalog.Energizable(dev.Subject) {
+			if dev.Energized {
+				energizedPanels = append(energizedPanels, dev.ID)
+			} else {
+				deEnergizedPanels = append(deEnergizedPanels, dev.ID)
+			}
+			if dev.EnergizedToday {
+				energizedTodayPanels = append(energizedTodayPanels, dev.ID)
+			} else {
+				deEnergizedTodayPanels = append(deEnergizedTodayPanels, dev.ID)
+			}
+		}
+	}
+
+	steps := []struct {
+		ids   []string
+		apply func([]string) error
+	}{
+		{energizedPanels, func(ids []string) error { return setConnectedPolylinesEnergized(ids, true) }},
+		{deEnergizedPanels, func(ids []string) error { return setConnectedPolylinesEnergized(ids, false) }},
+		{energizedTodayPanels, func(ids []string) error { return setConnectedPolylinesEnergizedToday(ids, true) }},
+		{deEnergizedTodayPanels, func(ids []string) error { return setConnectedPolylinesEnergizedToday(ids, false) }},
+	}
+	for _, s := range steps {
+		for start := 0; start < len(s.ids); start += importBatchSize {
+			end := min(start+importBatchSize, len(s.ids))
+			if err := s.apply(s.ids[start:end]); err != nil {
+				r.finish(models.ImportJobFailed, "propagate failed: "+err.Error())
+				return
+			}
+			r.job.Propagated += end - start
+			r.save()
+		}
+	}
+
+	r.finish(models.ImportJobSucceeded, "")
+}
+
+// publishImported 按项目推送这一批导入成功的设备 id，客户端按需重新拉取
+func (r *importJobRunner) publishImported(devices []models.Device) {
+	ids := map[string][]string{}
+	for _, d := range devices {
+		ids[d.Project] = append(ids[d.Project], d.ID)
+	}
+	for project, list := range ids {
+		publishEvent(project, models.EventDevicesImported, "", gin.H{"job_id": r.job.ID, "ids": list}, "")
+	}
+}
+
+// upsertBatch 导入 arr[start:end]，返回成功的行。
+// 整批失败时逐行重试，这样能定位到具体哪一行有问题，其余行照常导入。
+func (r *importJobRunner) upsertBatch(arr []models.Device, start, end int) []models.Device {
+	comments, err := existingComments(arr[start:end])
+	if err != nil {
+		for i := start; i < end; i++ {
+			r.rowError(i, arr[i].ID, err.Error())
+		}
+		return nil
+	}
+
+	rows := make([]int, 0, end-start)
+	batch := make([]models.Device, 0, end-start)
+	for i := start; i < end; i++ {
+		if arr[i].ID == "" {
+			r.rowError(i, "", "id is required")
+			continue
+		}
+		if ok, err := r.projectExists(arr[i].Project); err != nil {
+			r.rowError(i, arr[i].ID, err.Error())
+			continue
+		} else if !ok {
+			r.rowError(i, arr[i].ID, fmt.Sprintf("unknown project %q", arr[i].Project))
+			continue
+		}
+		if err := arr[i].ValidateGeometry(r.pageBounds(arr[i].Project, arr[i].FilePage)); err != nil {
+			r.rowError(i, arr[i].ID, err.Error())
+			continue
+		}
+		schemas, err := r.attributeSchemas(arr[i].Project)
+		if err != nil {
+			r.rowError(i, arr[i].ID, err.Error())
+			continue
+		}
+		// 图纸重新导出的数据不带属性：没有属性的行保留设备已有的铭牌属性
+		if len(arr[i].Attributes) > 0 {
+			attrs, err := schemas.Validate(arr[i].Subject, arr[i].Attributes)
+			if err != nil {
+				r.rowError(i, arr[i].ID, err.Error())
+				continue
+			}
+			arr[i].Attributes = attrs
+		} else {
+			arr[i].Attributes = nil
+		}
+		// 非建筑类的备注已经改成评论线程：导出带出来的旧值原样导回可以，改了就报错；
+		// 写回已有的值，不动 comments 列
+		if schemas.catalog.Category(arr[i].Subject) != models.SubjectArchitectural {
+			if arr[i].Comments != "" && arr[i].Comments != comments[arr[i].ID] {
+				r.rowError(i, arr[i].ID, errCommentsMoved.Error())
+				continue
+			}
+			arr[i].Comments = comments[arr[i].ID]
+		}
+		arr[i].CxLevel, arr[i].CxLevelAt = "", nil
+		arr[i].ActiveSource, arr[i].Position = "", ""
+		arr[i].Version = 0
+		if err := validateSourceRole(arr[i].SourceRole); err != nil {
+			r.rowError(i, arr[i].ID, err.Error())
+			continue
+		}
+		if arr[i].Energized || arr[i].EnergizedToday {
+			locked, err := r.lockedBranches(arr[i].Project)
+			if err != nil {
+				r.rowError(i, arr[i].ID, err.Error())
+				continue
+			}
+			if len(locked.Locks(arr[i].ID)) > 0 {
+				r.rowError(i, arr[i].ID, "device is in a locked-out branch")
+				continue
+			}
+		}
+		if arr[i].Energized {
+			unmet, err := r.energizeBlockers(&arr[i])
+			if err != nil {
+				r.rowError(i, arr[i].ID, err.Error())
+				continue
+			}
+			if len(unmet) > 0 {
+				details := make([]string, 0, len(unmet))
+				for _, u := range unmet {
+					details = append(details, u.Detail)
+				}
+				r.rowError(i, arr[i].ID, "device is not ready to energize: "+strings.Join(details, "; "))
+				continue
+			}
+		}
+		rows = append(rows, i)
+		batch = append(batch, arr[i])
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := upsertDevices(batch); err == nil {
+		r.job.Succeeded += len(batch)
+		return batch
+	}
+
+	var ok []models.Device
+	for k := range batch {
+		if err := upsertDevices(batch[k : k+1]); err != nil {
+			r.rowError(rows[k], batch[k].ID, err.Error())
+			continue
+		}
+		r.job.Succeeded++
+		ok = append(ok, batch[k])
+	}
+	return ok
+}
+
+// existingComments 一批导入行对应的已有设备的 comments（含已删除的，upsert 按 id 冲突也会覆盖它们）
+func existingComments(devices []models.Device) (map[string]string, error) {
+	ids := make([]string, 0, len(devices))
+	for _, d := range devices {
+		if d.ID != "" {
+			ids = append(ids, d.ID)
+		}
+	}
+	out := map[string]string{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	var existing []models.Device
+	if err := db.GetDB().Unscoped().Select("id", "comments").Where("id IN ?", ids).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	for _, d := range existing {
+		out[d.ID] = d.Comments
+	}
+	return out, nil
+}
+
+func upsertDevices(devices []models.Device) error {
+	// 图纸重新导出的数据不带属性和电源角色，导入行没带时保留设备已有的值
+	set := append(deviceUpsertSet(deviceUpsertColumns),
+		clause.Assignment{Column: clause.Column{Name: "attributes"}, Value: gorm.Expr("COALESCE(EXCLUDED.attributes, devices.attributes)")},
+		clause.Assignment{Column: clause.Column{Name: "source_role"}, Value: gorm.Expr("COALESCE(NULLIF(EXCLUDED.source_role, ''), devices.source_role)")},
+	)
+	return db.GetDB().Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: set,
+		},
+	).Create(&devices).Error
+}
+
+// deviceUpsertSet upsert 覆盖已有设备时用的 SET：给定列取新值，version 加一
+func deviceUpsertSet(columns []string) clause.Set {
+	return append(clause.AssignmentColumns(columns), clause.Assignment{
+		Column: clause.Column{Name: "version"},
+		Value:  gorm.Expr("devices.version + 1"),
+	})
+}
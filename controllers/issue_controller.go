@@ -0,0 +1,453 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var issueSeverities = []string{models.IssueLow, models.IssueMedium, models.IssueHigh, models.IssueCritical}
+var issueStatuses = []string{models.IssueOpen, models.IssueInProgress, models.IssueReadyForVerification, models.IssueClosed}
+
+// 允许的状态变化：现场处理 -> 提交验证 -> 关闭，不能跳过验证直接关闭；验证不通过退回，关闭后可以重开
+var issueTransitions = map[string][]string{
+	models.IssueOpen:                 {models.IssueInProgress, models.IssueReadyForVerification},
+	models.IssueInProgress:           {models.IssueOpen, models.IssueReadyForVerification},
+	models.IssueReadyForVerification: {models.IssueInProgress, models.IssueClosed},
+	models.IssueClosed:               {models.IssueOpen},
+}
+
+// GET /api/v1/devices/:id/issues
+func ListDeviceIssues(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	var list []models.Issue
+	if err := db.GetDB().Where("device_id = ?", deviceID).Order("created_at DESC").Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"device_id": deviceID,
+		"count":     len(list),
+		"data":      list,
+	})
+}
+
+// POST /api/v1/devices/:id/issues
+func CreateIssue(c *gin.Context) {
+	var body models.Issue
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	body.ID = 0
+	body.Project = dev.Project
+	body.DeviceID = dev.ID
+	body.Status = models.IssueOpen
+	body.ClosedAt, body.ClosedBy = nil, ""
+	body.Photos = nil
+	body.CreatedBy, _ = requestUser(c)
+	if body.Severity == "" {
+		body.Severity = models.IssueMedium
+	}
+	if err := validateIssue(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.GetDB().Create(&body).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, body)
+}
+
+// GET /api/v1/issues/:id
+func GetIssue(c *gin.Context) {
+	issue, ok := findIssue(c, true)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, issue)
+}
+
+// PUT /api/v1/issues/:id
+// 状态用 POST /issues/:id/status 修改。blocking 影响送电许可，修改需要登录
+func UpdateIssue(c *gin.Context) {
+	type updateDTO struct {
+		Title       *string    `json:"title"`
+		Description *string    `json:"description"`
+		Severity    *string    `json:"severity"`
+		Blocking    *bool      `json:"blocking"`
+		Assignee    *string    `json:"assignee"`
+		DueDate     *time.Time `json:"due_date"`
+		ClearDue    bool       `json:"clear_due_date"`
+	}
+	var req updateDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, _, ok := requireUser(c); !ok {
+		return
+	}
+	issue, ok := findIssue(c, false)
+	if !ok {
+		return
+	}
+	if req.Title != nil {
+		issue.Title = *req.Title
+	}
+	if req.Description != nil {
+		issue.Description = *req.Description
+	}
+	if req.Severity != nil {
+		issue.Severity = *req.Severity
+	}
+	if req.Blocking != nil {
+		issue.Blocking = *req.Blocking
+	}
+	if req.Assignee != nil {
+		issue.Assignee = *req.Assignee
+	}
+	if req.DueDate != nil {
+		issue.DueDate = req.DueDate
+	}
+	if req.ClearDue {
+		issue.DueDate = nil
+	}
+	if err := validateIssue(issue); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 只写这次改了的列，不能把读出来的 status / closed_* 写回去覆盖同时进行的状态变更
+	changes := map[string]any{}
+	if req.Title != nil {
+		changes["title"] = issue.Title
+	}
+	if req.Description != nil {
+		changes["description"] = issue.Description
+	}
+	if req.Severity != nil {
+		changes["severity"] = issue.Severity
+	}
+	if req.Blocking != nil {
+		changes["blocking"] = issue.Blocking
+	}
+	if req.Assignee != nil {
+		changes["assignee"] = issue.Assignee
+	}
+	if req.DueDate != nil || req.ClearDue {
+		changes["due_date"] = issue.DueDate
+	}
+	if len(changes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
+		return
+	}
+
+	if err := db.GetDB().Model(&models.Issue{}).Where("id = ?", issue.ID).Updates(changes).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := db.GetDB().First(issue, issue.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, issue)
+}
+
+// POST /api/v1/issues/:id/status
+// 请求体：{"status": "ready_for_verification"}。关闭要记录 closed_by，必须登录
+func UpdateIssueStatus(c *gin.Context) {
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	upn, _, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	issue, ok := findIssue(c, false)
+	if !ok {
+		return
+	}
+	if !slices.Contains(issueStatuses, req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of open, in_progress, ready_for_verification, closed"})
+		return
+	}
+	if !slices.Contains(issueTransitions[issue.Status], req.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("cannot change status from %s to %s", issue.Status, req.Status)})
+		return
+	}
+
+	changes := map[string]any{"status": req.Status}
+	if req.Status == models.IssueClosed {
+		changes["closed_at"] = time.Now()
+		changes["closed_by"] = upn
+	} else {
+		changes["closed_at"] = nil
+		changes["closed_by"] = ""
+	}
+	// 条件里带上原状态，防止两个人同时改
+	res := db.GetDB().Model(&models.Issue{}).Where("id = ? AND status = ?", issue.ID, issue.Status).Updates(changes)
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "issue status was changed by another request, reload and retry"})
+		return
+	}
+
+	if err := db.GetDB().First(issue, issue.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, issue)
+}
+
+// DELETE /api/v1/issues/:id
+// 照片保留在设备文件里，只是解除关联。删掉 blocking 的问题会放开送电许可，需要登录
+func DeleteIssue(c *gin.Context) {
+	if _, _, ok := requireUser(c); !ok {
+		return
+	}
+	id := c.Param("id")
+	err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		res := tx.Delete(&models.Issue{}, "id = ?", id)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Model(&models.DeviceFile{}).Where("issue_id = ?", id).Update("issue_id", nil).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "issue not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /api/v1/issues/:id/files
+// Content-Type: multipart/form-data，字段 file。保存为该设备 file_type=photo 的文件
+func UploadIssuePhoto(c *gin.Context) {
+	if _, _, ok := requireUser(c); !ok {
+		return
+	}
+	issue, ok := findIssue(c, false)
+	if !ok {
+		return
+	}
+
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", issue.DeviceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	record, ok := saveDeviceFile(c, &dev, "photo", &issue.ID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, record)
+}
+
+// GET /api/v1/projects/:project/issues
+// punch list，可选过滤：status（逗号分隔，open_only=1 表示所有未关闭）、severity、assignee、
+// device_id、blocking=1、overdue=1；传 page / size 时分页
+func ListProjectIssues(c *gin.Context) {
+	project := c.Param("project")
+
+	q := db.GetDB().Model(&models.Issue{}).Where("project = ?", project)
+	if s := c.Query("status"); s != "" {
+		q = q.Where("status IN ?", strings.Split(s, ","))
+	}
+	if c.Query("open_only") == "1" {
+		q = q.Where("status <> ?", models.IssueClosed)
+	}
+	if s := c.Query("severity"); s != "" {
+		q = q.Where("severity IN ?", strings.Split(s, ","))
+	}
+	if s := c.Query("assignee"); s != "" {
+		q = q.Where("assignee = ?", s)
+	}
+	if s := c.Query("device_id"); s != "" {
+		q = q.Where("device_id = ?", s)
+	}
+	if c.Query("blocking") == "1" {
+		q = q.Where("blocking = ?", true)
+	}
+	if c.Query("overdue") == "1" {
+		q = q.Where("status <> ? AND due_date < ?", models.IssueClosed, time.Now())
+	}
+
+	// 严重的排前面，同级按截止日期
+	order := "CASE severity WHEN 'critical' THEN 0 WHEN 'high' THEN 1 WHEN 'medium' THEN 2 ELSE 3 END, due_date NULLS LAST, created_at"
+
+	if c.Query("page") == "" && c.Query("size") == "" {
+		var list []models.Issue
+		if err := q.Order(order).Find(&list).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"project": project,
+			"count":   len(list),
+			"data":    list,
+		})
+		return
+	}
+
+	var p PaginationQuery
+	if err := c.ShouldBindQuery(&p); err != nil || p.Page < 1 || p.Size < 1 || p.Size > 1000 {
+		p = PaginationQuery{Page: 1, Size: 20}
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var list []models.Issue
+	if err := q.Order(order).Limit(p.Size).Offset((p.Page - 1) * p.Size).Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"project":    project,
+		"data":       list,
+		"pagination": gin.H{"page": p.Page, "size": p.Size, "total": total},
+	})
+}
+
+// GET /api/v1/projects/:project/issues/summary
+// 按状态、严重程度统计，另外给出未关闭的 blocking 数和逾期数
+func GetIssueSummary(c *gin.Context) {
+	project := c.Param("project")
+
+	type countRow struct {
+		Status   string
+		Severity string
+		Count    int
+	}
+	var rows []countRow
+	if err := db.GetDB().Model(&models.Issue{}).
+		Select("status, severity, COUNT(*) AS count").
+		Where("project = ?", project).
+		Group("status, severity").
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	byStatus := map[string]int{}
+	for _, s := range issueStatuses {
+		byStatus[s] = 0
+	}
+	openBySeverity := map[string]int{}
+	for _, s := range issueSeverities {
+		openBySeverity[s] = 0
+	}
+	total := 0
+	for _, r := range rows {
+		total += r.Count
+		byStatus[r.Status] += r.Count
+		if r.Status != models.IssueClosed {
+			openBySeverity[r.Severity] += r.Count
+		}
+	}
+
+	var blocking, overdue int64
+	if err := db.GetDB().Model(&models.Issue{}).
+		Where("project = ? AND status <> ? AND blocking = ?", project, models.IssueClosed, true).
+		Count(&blocking).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := db.GetDB().Model(&models.Issue{}).
+		Where("project = ? AND status <> ? AND due_date < ?", project, models.IssueClosed, time.Now()).
+		Count(&overdue).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project":          project,
+		"total":            total,
+		"by_status":        byStatus,
+		"open_by_severity": openBySeverity,
+		"open_blocking":    blocking,
+		"overdue":          overdue,
+	})
+}
+
+// findIssue 按 :id 查问题，withPhotos 时带上照片；找不到时已经写好 404
+func findIssue(c *gin.Context, withPhotos bool) (*models.Issue, bool) {
+	q := db.GetDB()
+	if withPhotos {
+		q = q.Preload("Photos")
+	}
+	var issue models.Issue
+	if err := q.First(&issue, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "issue not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &issue, true
+}
+
+func validateIssue(i *models.Issue) error {
+	i.Title = strings.TrimSpace(i.Title)
+	if i.Title == "" {
+		return errors.New("title is required")
+	}
+	if !slices.Contains(issueSeverities, i.Severity) {
+		return errors.New("severity must be one of low, medium, high, critical")
+	}
+	return nil
+}
+
+// openBlockingIssues 设备上未关闭的 blocking 问题，送电许可用
+func openBlockingIssues(deviceID string) ([]unmetPrerequisite, error) {
+	var list []models.Issue
+	if err := db.GetDB().
+		Where("device_id = ? AND blocking = ? AND status <> ?", deviceID, true, models.IssueClosed).
+		Order("created_at").
+		Find(&list).Error; err != nil {
+		return nil, err
+	}
+	unmet := make([]unmetPrerequisite, 0, len(list))
+	for _, i := range list {
+		unmet = append(unmet, unmetPrerequisite{Code: "open_issue", Detail: fmt.Sprintf("issue #%d %q is %s", i.ID, i.Title, i.Status)})
+	}
+	return unmet, nil
+}
@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 负载汇总结果里的状态
+const (
+	loadUnrated    = "unrated"    // 没有额定容量，无法判断
+	loadOK         = "ok"         // 低于 warn_pct
+	loadWarning    = "warning"    // 达到 warn_pct
+	loadOverloaded = "overloaded" // 超过额定容量
+)
+
+// loadNode 一个节点的负载汇总
+type loadNode struct {
+	ID           string   `json:"id"`
+	Text         string   `json:"text"`
+	Subject      string   `json:"subject"`
+	Category     string   `json:"category"`
+	Depth        int      `json:"depth"` // 到最近电源的级数，电源为 0
+	Parents      []string `json:"parents,omitempty"`
+	Children     []string `json:"children,omitempty"`
+	CircuitCount int      `json:"circuit_count"`
+
+	OwnKVA         float64  `json:"own_kva"`       // 本身的负载（回路里没有下游设备的部分）
+	DemandFactor   float64  `json:"demand_factor"` // 本身负载用的需用系数
+	ConnectedKVA   float64  `json:"connected_kva"` // 本身 + 所有下游的连接负载
+	DemandKVA      float64  `json:"demand_kva"`    // 按需用系数折算后的负载
+	RatingKVA      *float64 `json:"rating_kva,omitempty"`
+	UtilizationPct *float64 `json:"utilization_pct,omitempty"` // demand / rating
+	Status         string   `json:"status"`
+}
+
+// GET /api/v1/projects/:project/load-rollup?root=<device id>&demand_factor=0.8&warn_pct=80
+// 从 panel 的回路负载开始，沿 PolyLine / 回路向上汇总到 bus、transformer 直到电源，
+// 并标出需用负载超过（或接近）额定容量的设备。
+// 额定容量：属性 kva；没有时用 amperage × voltage（三相再乘 √3）。
+// 设备属性 demand_factor 优先于 query 里的 demand_factor（默认 1）
+func GetLoadRollup(c *gin.Context) {
+	project := c.Param("project")
+
+	defaultDF := 1.0
+	if v := c.Query("demand_factor"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 || f > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "demand_factor must be in (0, 1]"})
+			return
+		}
+		defaultDF = f
+	}
+	warnPct := 80.0
+	if v := c.Query("warn_pct"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "warn_pct must be a positive number"})
+			return
+		}
+		warnPct = f
+	}
+
+	t, err := loadTopology(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	root := c.Query("root")
+	if root != "" && t.Nodes[root] == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "root device not found in project"})
+		return
+	}
+
+	nodes, cycles, err := rollupLoads(t, defaultDF, warnPct)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ids []string
+	if root != "" {
+		ids = append([]string{root}, t.Downstream(root)...)
+	} else {
+		for id := range nodes {
+			ids = append(ids, id)
+		}
+	}
+	out := make([]*loadNode, 0, len(ids))
+	var flagged []*loadNode
+	for _, id := range ids {
+		n, ok := nodes[id]
+		if !ok {
+			continue
+		}
+		out = append(out, n)
+		if n.Status == loadWarning || n.Status == loadOverloaded {
+			flagged = append(flagged, n)
+		}
+	}
+	sortLoadNodes(out)
+	sortLoadNodes(flagged)
+
+	c.JSON(http.StatusOK, gin.H{
+		"project":       project,
+		"root":          root,
+		"demand_factor": defaultDF,
+		"warn_pct":      warnPct,
+		"count":         len(out),
+		"data":          out,
+		"flagged":       flagged,
+		"cycles":        cycles,
+	})
+}
+
+func sortLoadNodes(list []*loadNode) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Depth != list[j].Depth {
+			return list[i].Depth < list[j].Depth
+		}
+		return list[i].ID < list[j].ID
+	})
+}
+
+// rollupLoads 计算拓扑里每个节点的连接负载 / 需用负载。
+// 有多个上游的节点（比如双电源）会被计入每一个上游，结果偏保守；
+// 遇到环时环上那条边不计入，并在 cycles 里返回形成环的边
+func rollupLoads(t *topology, defaultDF, warnPct float64) (map[string]*loadNode, []string, error) {
+	var circuits []models.PanelCircuit
+	if err := db.GetDB().Where("project = ?", t.Project).Find(&circuits).Error; err != nil {
+		return nil, nil, err
+	}
+	own := map[string]float64{}
+	circuitCount := map[string]int{}
+	for _, ci := range circuits {
+		circuitCount[ci.PanelID]++
+		// 有下游设备的回路，负载由下游设备自己汇总，避免重复计算
+		if ci.DownstreamID != "" && t.Nodes[ci.DownstreamID] != nil {
+			continue
+		}
+		own[ci.PanelID] += ci.LoadKVA
+	}
+
+	nodes := map[string]*loadNode{}
+	for id, d := range t.Nodes {
+		if len(t.Down[id]) == 0 && len(t.Up[id]) == 0 && circuitCount[id] == 0 {
+			continue
+		}
+		n := &loadNode{
+			ID:           id,
+			Text:         d.Text,
+			Subject:      d.Subject,
+			Category:     t.Catalog.Category(d.Subject),
+			Depth:        -1,
+			Parents:      t.Parents(id),
+			Children:     t.Children(id),
+			CircuitCount: circuitCount[id],
+			OwnKVA:       own[id],
+			DemandFactor: defaultDF,
+		}
+		// 没有回路的设备可以直接在属性里填 load_kva
+		if circuitCount[id] == 0 {
+			if v, ok := attributeNumber(d.Attributes["load_kva"]); ok {
+				n.OwnKVA = v
+			}
+		}
+		if v, ok := attributeNumber(d.Attributes["demand_factor"]); ok && v > 0 && v <= 1 {
+			n.DemandFactor = v
+		}
+		nodes[id] = n
+	}
+
+	// 深度：从各个电源 BFS 的最短级数
+	queue := t.Roots()
+	for _, id := range queue {
+		if n := nodes[id]; n != nil {
+			n.Depth = 0
+		}
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, ch := range t.Children(cur) {
+			if n := nodes[ch]; n != nil && n.Depth < 0 {
+				n.Depth = nodes[cur].Depth + 1
+				queue = append(queue, ch)
+			}
+		}
+	}
+
+	// 后序 DFS 汇总
+	done := map[string]bool{}
+	onStack := map[string]bool{}
+	var cycles []string
+	var visit func(id string)
+	visit = func(id string) {
+		n := nodes[id]
+		onStack[id] = true
+		n.ConnectedKVA = n.OwnKVA
+		n.DemandKVA = n.OwnKVA * n.DemandFactor
+		for _, e := range t.Down[id] {
+			// ATS 等多电源设备的负载只算在当前供电的那一路上，避免重复
+			if !e.Active {
+				continue
+			}
+			if onStack[e.To] {
+				cycles = append(cycles, fmt.Sprintf("%s -> %s (%s)", e.From, e.To, e.Via))
+				continue
+			}
+			if !done[e.To] {
+				visit(e.To)
+			}
+			n.ConnectedKVA += nodes[e.To].ConnectedKVA
+			n.DemandKVA += nodes[e.To].DemandKVA
+		}
+		onStack[id] = false
+		done[id] = true
+	}
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if !done[id] {
+			visit(id)
+		}
+	}
+
+	for _, n := range nodes {
+		if n.Depth < 0 {
+			n.Depth = 0 // 孤立在环里的节点
+		}
+		n.OwnKVA = roundKVA(n.OwnKVA)
+		n.ConnectedKVA = roundKVA(n.ConnectedKVA)
+		n.DemandKVA = roundKVA(n.DemandKVA)
+		n.Status = loadUnrated
+		rating, ok := ratingKVA(t.Nodes[n.ID])
+		if !ok {
+			continue
+		}
+		util := math.Round(n.DemandKVA/rating*1000) / 10
+		n.RatingKVA = &rating
+		n.UtilizationPct = &util
+		switch {
+		case n.DemandKVA > rating:
+			n.Status = loadOverloaded
+		case util >= warnPct:
+			n.Status = loadWarning
+		default:
+			n.Status = loadOK
+		}
+	}
+	return nodes, cycles, nil
+}
+
+// ratingKVA 设备的额定容量（kVA）。优先用属性 kva，
+// 否则用 amperage × voltage，phase 不是 "1" 时按三相乘 √3
+func ratingKVA(d *models.Device) (float64, bool) {
+	if v, ok := attributeNumber(d.Attributes["kva"]); ok && v > 0 {
+		return v, true
+	}
+	amps, okA := attributeNumber(d.Attributes["amperage"])
+	volts, okV := attributeNumber(d.Attributes["voltage"])
+	if !okA || !okV || amps <= 0 || volts <= 0 {
+		return 0, false
+	}
+	kva := amps * volts / 1000
+	if fmt.Sprint(d.Attributes["phase"]) != "1" {
+		kva *= math.Sqrt(3)
+	}
+	return roundKVA(kva), true
+}
+
+func roundKVA(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"math"
+)
+
+// deviceLocation 设备所在的房间 / 楼层
+type deviceLocation struct {
+	Room  string `json:"room,omitempty"`
+	Level string `json:"level,omitempty"`
+}
+
+// locateDevices 根据图纸上的 Room Line / Level Line 推算每个设备的房间和楼层。
+// 这两类标注的名字都写在 comments 里（例如 "WEST IT ROOM"、"L0"）。
+//
+// 规则（同一页内）：
+//   - 楼层：Level Line 是楼板线，设备中心点下方最近的那条线就是它所在的楼层
+//   - 房间：Room Line 标在房间顶部，x 范围覆盖设备中心、且在设备上方最近的那条
+//
+// 找不到对应标注的设备不会出现在返回的 map 里。
+func locateDevices(project string, devices []models.Device) (map[string]deviceLocation, error) {
+	var marks []models.Device
+	if err := db.GetDB().
+		Select("id, file_page, subject, rect_px, comments").
+		Where("project = ? AND subject IN ?", project, []string{"Room Line", "Level Line"}).
+		Find(&marks).Error; err != nil {
+		return nil, err
+	}
+
+	type line struct {
+		name       string
+		x1, x2, y1 float64
+	}
+	rooms := map[int][]line{}
+	levels := map[int][]line{}
+	for _, m := range marks {
+		if len(m.RectPX) != 4 || m.Comments == "" {
+			continue
+		}
+		l := line{name: m.Comments, x1: float64(m.RectPX[0]), x2: float64(m.RectPX[2]), y1: float64(m.RectPX[1])}
+		if m.Subject == "Room Line" {
+			rooms[m.FilePage] = append(rooms[m.FilePage], l)
+		} else {
+			levels[m.FilePage] = append(levels[m.FilePage], l)
+		}
+	}
+
+	out := make(map[string]deviceLocation, len(devices))
+	for _, d := range devices {
+		if len(d.RectPX) != 4 {
+			continue
+		}
+		cx := float64(d.RectPX[0]+d.RectPX[2]) / 2
+		cy := float64(d.RectPX[1]+d.RectPX[3]) / 2
+
+		var loc deviceLocation
+
+		best := math.Inf(1)
+		for _, l := range levels[d.FilePage] {
+			if l.y1 > cy && l.y1-cy < best && cx >= l.x1 && cx <= l.x2 {
+				best = l.y1 - cy
+				loc.Level = l.name
+			}
+		}
+
+		best = math.Inf(1)
+		for _, l := range rooms[d.FilePage] {
+			if l.y1 <= cy && cy-l.y1 < best && cx >= l.x1 && cx <= l.x2 {
+				best = cy - l.y1
+				loc.Room = l.name
+			}
+		}
+
+		if loc.Room != "" || loc.Level != "" {
+			out[d.ID] = loc
+		}
+	}
+	return out, nil
+}
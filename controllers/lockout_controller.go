@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 可以替别人解锁的 Entra app role（例如持锁人离场时由安全负责人解锁）
+var lockoutAdminRoles = []string{"Lockout.Admin"}
+
+// lockedBranches 项目里所有被锁住的支路：上锁设备本身、它的全部下游，
+// 以及两端有一端在支路里的 PolyLine
+type lockedBranches struct {
+	byDevice map[string][]models.Lockout // 设备 / PolyLine id -> 导致它被锁住的锁
+}
+
+// loadLockedBranches 读出项目当前有效的锁，沿拓扑向下展开成被锁住的设备集合
+func loadLockedBranches(project string) (*lockedBranches, error) {
+	b := &lockedBranches{byDevice: map[string][]models.Lockout{}}
+
+	var locks []models.Lockout
+	if err := db.GetDB().Where("project = ? AND removed_at IS NULL", project).Order("applied_at").Find(&locks).Error; err != nil {
+		return nil, err
+	}
+	if len(locks) == 0 {
+		return b, nil
+	}
+
+	t, err := loadTopology(project)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range locks {
+		for _, id := range append([]string{l.DeviceID}, t.Downstream(l.DeviceID)...) {
+			b.byDevice[id] = append(b.byDevice[id], l)
+		}
+	}
+	for id, line := range t.Lines {
+		if locks, ok := b.byDevice[line.To]; ok {
+			b.byDevice[id] = locks
+		} else if locks, ok := b.byDevice[line.From]; ok {
+			b.byDevice[id] = locks
+		}
+	}
+	return b, nil
+}
+
+// Locks 导致 id 被锁住的锁，没锁住时为空
+func (b *lockedBranches) Locks(id string) []models.Lockout {
+	return b.byDevice[id]
+}
+
+// IDs 所有被锁住的设备 / PolyLine id
+func (b *lockedBranches) IDs() []string {
+	ids := make([]string, 0, len(b.byDevice))
+	for id := range b.byDevice {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// GET /api/v1/devices/:id/lockouts
+// 设备的上锁记录（含已解除的），新的在前
+func ListDeviceLockouts(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	var list []models.Lockout
+	if err := db.GetDB().Where("device_id = ?", deviceID).Order("applied_at DESC").Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"device_id": deviceID,
+		"count":     len(list),
+		"data":      list,
+	})
+}
+
+// POST /api/v1/devices/:id/lockouts
+// 请求体：{"lock_id": "L-042", "holder": "jane.doe@contoso.com", "permit": "EWP-1187", "notes": "..."}
+// 需要登录；holder 不填时用当前用户，解锁时按 holder 的登录身份认人。设备必须已经停电
+func ApplyLockout(c *gin.Context) {
+	var body models.Lockout
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+	if dev.Energized || dev.EnergizedToday {
+		c.JSON(http.StatusConflict, gin.H{"error": "de-energize the device before applying a lock"})
+		return
+	}
+
+	// 上锁的人必须能追溯，之后也要靠登录身份解锁
+	upn, _, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	body.LockID = strings.TrimSpace(body.LockID)
+	body.Holder = strings.TrimSpace(body.Holder)
+	if body.LockID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lock_id is required"})
+		return
+	}
+	if body.Holder == "" {
+		body.Holder = upn
+	}
+
+	var n int64
+	if err := db.GetDB().Model(&models.Lockout{}).
+		Where("project = ? AND lock_id = ? AND removed_at IS NULL", dev.Project, body.LockID).
+		Count(&n).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "lock " + body.LockID + " is already applied in this project"})
+		return
+	}
+
+	body.ID = 0
+	body.Project = dev.Project
+	body.DeviceID = dev.ID
+	body.AppliedAt = time.Now()
+	body.AppliedBy = upn
+	body.RemovedAt, body.RemovedBy, body.RemovalNotes = nil, "", ""
+
+	if err := db.GetDB().Create(&body).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	publishEvent(body.Project, models.EventLockoutApplied, body.DeviceID, body, upn)
+	c.JSON(http.StatusCreated, body)
+}
+
+// POST /api/v1/lockouts/:id/remove
+// 请求体：{"notes": "..."}。需要登录，只有持锁人本人或 Lockout.Admin 角色可以解锁
+func RemoveLockout(c *gin.Context) {
+	var req struct {
+		Notes string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var l models.Lockout
+	if err := db.GetDB().First(&l, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "lockout not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if l.RemovedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "lock is already removed"})
+		return
+	}
+
+	upn, _, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	if !strings.EqualFold(upn, l.Holder) && !requestHasRole(c, lockoutAdminRoles) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the lock holder or a lockout admin can remove this lock"})
+		return
+	}
+
+	now := time.Now()
+	res := db.GetDB().Model(&models.Lockout{}).
+		Where("id = ? AND removed_at IS NULL", l.ID).
+		Updates(map[string]any{"removed_at": now, "removed_by": upn, "removal_notes": strings.TrimSpace(req.Notes)})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "lock is already removed"})
+		return
+	}
+
+	_ = db.GetDB().First(&l, l.ID).Error
+	publishEvent(l.Project, models.EventLockoutRemoved, l.DeviceID, l, upn)
+	c.JSON(http.StatusOK, l)
+}
+
+// GET /api/v1/projects/:project/lockouts/active
+// 项目当前所有有效的锁，带上设备信息和被锁住的下游设备数
+func ListActiveLockouts(c *gin.Context) {
+	project := c.Param("project")
+
+	var locks []models.Lockout
+	if err := db.GetDB().Where("project = ? AND removed_at IS NULL", project).Order("applied_at").Find(&locks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	type activeLock struct {
+		models.Lockout
+		DeviceText      string   `json:"device_text"`
+		DeviceSubject   string   `json:"device_subject"`
+		FilePage        int      `json:"file_page"`
+		DownstreamCount int      `json:"downstream_count"`
+		Downstream      []string `json:"downstream,omitempty"`
+	}
+	out := make([]activeLock, 0, len(locks))
+	if len(locks) > 0 {
+		t, err := loadTopology(project)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, l := range locks {
+			item := activeLock{Lockout: l}
+			if d := t.Nodes[l.DeviceID]; d != nil {
+				item.DeviceText, item.DeviceSubject, item.FilePage = d.Text, d.Subject, d.FilePage
+			}
+			item.Downstream = t.Downstream(l.DeviceID)
+			item.DownstreamCount = len(item.Downstream)
+			out = append(out, item)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project": project,
+		"count":   len(out),
+		"data":    out,
+	})
+}
+
+// rejectIfLockedOut 设备处在被锁住的支路上时写好 409 并返回 true。
+// 和送电许可不同，上锁不能 override，只能先解锁。
+// 还没建进拓扑的新 PolyLine 和已有的一样，按两端设备的锁算
+func rejectIfLockedOut(c *gin.Context, dev *models.Device) bool {
+	b, err := loadLockedBranches(dev.Project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	locks := b.Locks(dev.ID)
+	if len(locks) == 0 && dev.To != "" {
+		locks = b.Locks(dev.To)
+	}
+	if len(locks) == 0 && dev.From != "" {
+		locks = b.Locks(dev.From)
+	}
+	if len(locks) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "device is in a locked-out branch", "locks": locks})
+		return true
+	}
+	return false
+}
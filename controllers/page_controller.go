@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/config"
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GET /api/v1/projects/:project/pages
+func ListPages(c *gin.Context) {
+	project := c.Param("project")
+	dbx := db.GetDB()
+
+	var pages []models.Page
+	if err := dbx.Where("project = ?", project).Order("file_page").Find(&pages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 每页的标注数量
+	type countRow struct {
+		FilePage int
+		Count    int64
+	}
+	var rows []countRow
+	if err := dbx.Model(&models.Device{}).
+		Select("file_page, COUNT(*) AS count").
+		Where("project = ?", project).
+		Group("file_page").
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	m := make(map[int]int64, len(rows))
+	for _, r := range rows {
+		m[r.FilePage] = r.Count
+	}
+	for i := range pages {
+		pages[i].MarkupCount = m[pages[i].FilePage]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project": project,
+		"count":   len(pages),
+		"data":    pages,
+	})
+}
+
+// GET /api/v1/projects/:project/pages/:page
+func GetPage(c *gin.Context) {
+	p, ok := findPage(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// POST /api/v1/projects/:project/pages
+func CreatePage(c *gin.Context) {
+	project := c.Param("project")
+
+	var body models.Page
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	body.ID = 0
+	body.Project = project
+	body.SourceName, body.SourcePath, body.SourceMime, body.SourceSize = "", "", "", 0
+	if err := validatePage(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if ok, err := projectExists(project); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+
+	var n int64
+	if err := db.GetDB().Model(&models.Page{}).
+		Where("project = ? AND file_page = ?", project, body.FilePage).
+		Count(&n).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "page already exists"})
+		return
+	}
+
+	if err := db.GetDB().Create(&body).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, body)
+}
+
+// PUT /api/v1/projects/:project/pages/:page
+func UpdatePage(c *gin.Context) {
+	type updateDTO struct {
+		SheetNumber *string `json:"sheet_number"`
+		Title       *string `json:"title"`
+		Revision    *string `json:"revision"`
+		WidthPX     *int    `json:"width_px"`
+		HeightPX    *int    `json:"height_px"`
+		DPI         *int    `json:"dpi"`
+	}
+	var req updateDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	p, ok := findPage(c)
+	if !ok {
+		return
+	}
+	if req.SheetNumber != nil {
+		p.SheetNumber = *req.SheetNumber
+	}
+	if req.Title != nil {
+		p.Title = *req.Title
+	}
+	if req.Revision != nil {
+		p.Revision = *req.Revision
+	}
+	if req.WidthPX != nil {
+		p.WidthPX = *req.WidthPX
+	}
+	if req.HeightPX != nil {
+		p.HeightPX = *req.HeightPX
+	}
+	if req.DPI != nil {
+		p.DPI = *req.DPI
+	}
+	if err := validatePage(p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.GetDB().Save(p).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// DELETE /api/v1/projects/:project/pages/:page
+// 只删页面信息和源文件，不动该页上的设备
+func DeletePage(c *gin.Context) {
+	p, ok := findPage(c)
+	if !ok {
+		return
+	}
+	if err := db.GetDB().Delete(p).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db delete failed"})
+		return
+	}
+	if p.SourcePath != "" {
+		_ = os.Remove(p.SourcePath)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /api/v1/projects/:project/pages/:page/source
+// Content-Type: multipart/form-data
+// 字段：file（PDF 或 png/jpeg 底图）。页面不存在时自动创建；
+// 上传的是图片且页面还没有尺寸时，用图片的像素尺寸填充 width_px / height_px
+func UploadPageSource(c *gin.Context) {
+	project := c.Param("project")
+	pageNum, err := strconv.Atoi(c.Param("page"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		return
+	}
+
+	if ok, err := projectExists(project); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+
+	p := models.Page{Project: project, FilePage: pageNum}
+	if err := db.GetDB().
+		Where("project = ? AND file_page = ?", project, pageNum).
+		FirstOrInit(&p).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	pageDir := filepath.Join(config.UploadDir(), project, "pages")
+	if err := os.MkdirAll(pageDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot create upload dir"})
+		return
+	}
+
+	timestamp := time.Now().Format("20060102_150405")
+	safeName := fmt.Sprintf("page%d_%s_%s", pageNum, timestamp, filepath.Base(fileHeader.Filename))
+	dstPath := filepath.Join(pageDir, safeName)
+
+	if err := c.SaveUploadedFile(fileHeader, dstPath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "save file failed"})
+		return
+	}
+
+	if p.WidthPX == 0 || p.HeightPX == 0 {
+		if f, err := os.Open(dstPath); err == nil {
+			if cfg, _, err := image.DecodeConfig(f); err == nil {
+				p.WidthPX, p.HeightPX = cfg.Width, cfg.Height
+			}
+			f.Close()
+		}
+	}
+
+	oldPath := p.SourcePath
+	p.SourceName = fileHeader.Filename
+	p.SourcePath = dstPath
+	p.SourceMime = fileHeader.Header.Get("Content-Type")
+	p.SourceSize = fileHeader.Size
+
+	if err := db.GetDB().Save(&p).Error; err != nil {
+		_ = os.Remove(dstPath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db save failed"})
+		return
+	}
+	if oldPath != "" && oldPath != dstPath {
+		_ = os.Remove(oldPath)
+	}
+
+	c.JSON(http.StatusCreated, p)
+}
+
+// GET /api/v1/projects/:project/pages/:page/source
+func DownloadPageSource(c *gin.Context) {
+	p, ok := findPage(c)
+	if !ok {
+		return
+	}
+	if p.SourcePath == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "page has no source file"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", p.SourceName))
+	c.Header("Content-Type", p.SourceMime)
+	c.File(p.SourcePath)
+}
+
+// findPage 按 :project / :page 查页面；找不到时已经写好 404
+func findPage(c *gin.Context) (*models.Page, bool) {
+	pageNum, err := strconv.Atoi(c.Param("page"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		return nil, false
+	}
+
+	var p models.Page
+	if err := db.GetDB().First(&p, "project = ? AND file_page = ?", c.Param("project"), pageNum).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "page not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &p, true
+}
+
+func validatePage(p *models.Page) error {
+	if p.FilePage < 0 {
+		return errors.New("file_page must not be negative")
+	}
+	if p.WidthPX < 0 || p.HeightPX < 0 || p.DPI < 0 {
+		return errors.New("width_px, height_px and dpi must not be negative")
+	}
+	return nil
+}
+
+// loadPage 查项目的某一页，没有登记时返回 nil
+func loadPage(project string, filePage int) *models.Page {
+	var p models.Page
+	if err := db.GetDB().First(&p, "project = ? AND file_page = ?", project, filePage).Error; err != nil {
+		return nil
+	}
+	return &p
+}
+
+// pageBounds 设备几何校验用的页面边界，页面未登记或没有尺寸时返回 nil（只做基本校验）
+func pageBounds(project string, filePage int) *models.PageBounds {
+	return loadPage(project, filePage).Bounds()
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 允许的开关操作：跳闸后要先复位（open）才能合闸，摇出的要先摇进（open）
+var positionTransitions = map[string][]string{
+	"":                       models.Positions,
+	models.PositionClosed:    {models.PositionOpen, models.PositionTripped},
+	models.PositionOpen:      {models.PositionClosed, models.PositionRackedOut},
+	models.PositionTripped:   {models.PositionOpen},
+	models.PositionRackedOut: {models.PositionOpen},
+}
+
+// GET /api/v1/devices/:id/operations
+// 开关的当前位置和操作记录，新的在前
+func ListSwitchOperations(c *gin.Context) {
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	var list []models.SwitchOperation
+	if err := db.GetDB().Where("device_id = ?", dev.ID).Order("created_at DESC, id DESC").Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"device_id": dev.ID,
+		"position":  dev.Position,
+		"count":     len(list),
+		"data":      list,
+	})
+}
+
+// POST /api/v1/devices/:id/operate
+// 请求体：{"position": "open", "notes": "..."}
+// 只能操作 switching 类设备。分闸（open / tripped / racked_out）后，下游没有其他电源的设备
+// 和相连的 PolyLine 都置为未送电，和位置变化在同一个事务里写入；合闸不会自动给下游送电，
+// 但下游有锁时拒绝合闸。操作要记录操作人，必须登录
+func OperateSwitch(c *gin.Context) {
+	var req struct {
+		Position string `json:"position"`
+		Notes    string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	upn, oid, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	req.Position = strings.ToLower(strings.TrimSpace(req.Position))
+	if !slices.Contains(models.Positions, req.Position) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "position must be one of closed, open, racked_out, tripped"})
+		return
+	}
+
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+	t, err := loadTopology(dev.Project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if t.Catalog.Category(dev.Subject) != models.SubjectSwitching {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only switching devices have a position"})
+		return
+	}
+	if !slices.Contains(positionTransitions[dev.Position], req.Position) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("cannot change position from %q to %s", dev.Position, req.Position)})
+		return
+	}
+
+	var lost []string
+	if req.Position == models.PositionClosed {
+		// 上锁挂牌：开关本身或下游被锁住时不能合闸
+		locked, err := loadLockedBranches(dev.Project)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var locks []models.Lockout
+		for _, id := range append([]string{dev.ID}, t.Downstream(dev.ID)...) {
+			for _, l := range locked.Locks(id) {
+				if !slices.ContainsFunc(locks, func(x models.Lockout) bool { return x.ID == l.ID }) {
+					locks = append(locks, l)
+				}
+			}
+		}
+		if len(locks) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot close into a locked-out branch", "locks": locks})
+			return
+		}
+	} else if models.PositionConducts(dev.Position) {
+		lost, _ = outageImpact(t, dev.ID)
+	}
+
+	op := models.SwitchOperation{
+		Project:       dev.Project,
+		DeviceID:      dev.ID,
+		FromPosition:  dev.Position,
+		ToPosition:    req.Position,
+		Notes:         strings.TrimSpace(req.Notes),
+		OperatedBy:    upn,
+		OperatedByOID: oid,
+	}
+	for _, id := range lost {
+		if t.Nodes[id].Energized {
+			op.DeEnergized++
+		}
+	}
+	var s *powerState
+	if len(lost) > 0 {
+		s, err = loadPowerState(dev.Project)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		s.isolate(dev.ID, lost, t.Catalog)
+	}
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		// 条件里带上原位置，防止两个人同时操作；加列前已有的设备 position 是 NULL
+		res := tx.Model(&models.Device{}).
+			Where("id = ? AND COALESCE(position, '') = ?", dev.ID, dev.Position).
+			Update("position", req.Position)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errPositionChanged
+		}
+		if err := tx.Create(&op).Error; err != nil {
+			return err
+		}
+		// 下游断电和位置一起提交，不会出现开关已分闸、下游还显示送电
+		if s != nil {
+			return s.write(tx)
+		}
+		return nil
+	})
+	if errors.Is(err, errPositionChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if s != nil {
+		s.publish()
+	}
+
+	publishDeviceEvent(c, models.EventDeviceUpdated, dev.ID)
+	c.JSON(http.StatusCreated, gin.H{
+		"operation": op,
+		"isolated":  lost,
+	})
+}
+
+var errPositionChanged = errors.New("position was changed by another request, reload and retry")
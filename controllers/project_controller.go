@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// 项目未配置时的默认规则
+var (
+	defaultEquipmentSubjects   = []string{"panel board", "transformer", "Generator", "ATS"}
+	defaultPropagationSubjects = []string{"panel board", "Breaker", "Bus Breaker", "transformer"}
+	// L1 只记录见证，之后每级要求该级检查表完成，L4 另外要求测试报告
+	defaultCxRequirements = map[string]models.CxRequirement{
+		models.CxL1: {},
+		models.CxL2: {RequireChecklist: true},
+		models.CxL3: {RequireChecklist: true},
+		models.CxL4: {FileTypes: []string{"test_report"}, RequireChecklist: true},
+		models.CxL5: {RequireChecklist: true},
+	}
+	defaultGateFileTypes     = []string{"test_report"}
+	defaultGateOverrideRoles = []string{"Energization.Override"}
+)
+
+var projectStatuses = []string{models.ProjectActive, models.ProjectOnHold, models.ProjectCompleted, models.ProjectArchived}
+
+// 项目名会出现在 URL 和上传目录里，只允许字母数字和 - _
+var projectNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// GET /api/v1/projects?status=active
+func ListProjects(c *gin.Context) {
+	d := db.GetDB().Model(&models.Project{})
+	if status := c.Query("status"); status != "" {
+		d = d.Where("status = ?", status)
+	}
+
+	var projects []models.Project
+	if err := d.Order("name").Find(&projects).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"count": len(projects), "data": projects})
+}
+
+// GET /api/v1/projects/:project
+func GetProject(c *gin.Context) {
+	var p models.Project
+	if err := db.GetDB().First(&p, "name = ?", c.Param("project")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// POST /api/v1/projects
+func CreateProject(c *gin.Context) {
+	var body models.Project
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !projectNamePattern.MatchString(body.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required and may only contain letters, digits, - and _"})
+		return
+	}
+	if body.DisplayName == "" {
+		body.DisplayName = body.Name
+	}
+	if body.Timezone == "" {
+		body.Timezone = "UTC"
+	}
+	if body.Status == "" {
+		body.Status = models.ProjectActive
+	}
+	if err := validateProject(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	exists, err := projectExists(body.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "project already exists"})
+		return
+	}
+
+	if err := db.GetDB().Create(&body).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, body)
+}
+
+// PUT /api/v1/projects/:project
+// name 是主键，不能改
+func UpdateProject(c *gin.Context) {
+	type updateDTO struct {
+		DisplayName     *string                 `json:"display_name"`
+		SiteAddress     *string                 `json:"site_address"`
+		Timezone        *string                 `json:"timezone"`
+		Owner           *string                 `json:"owner"`
+		Status          *string                 `json:"status"`
+		DrawingSet      *string                 `json:"drawing_set"`
+		DrawingRevision *string                 `json:"drawing_revision"`
+		Settings        *models.ProjectSettings `json:"settings"`
+	}
+	var req updateDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var p models.Project
+	if err := db.GetDB().First(&p, "name = ?", c.Param("project")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.DisplayName != nil {
+		p.DisplayName = *req.DisplayName
+	}
+	if req.SiteAddress != nil {
+		p.SiteAddress = *req.SiteAddress
+	}
+	if req.Timezone != nil {
+		p.Timezone = *req.Timezone
+	}
+	if req.Owner != nil {
+		p.Owner = *req.Owner
+	}
+	if req.Status != nil {
+		p.Status = *req.Status
+	}
+	if req.DrawingSet != nil {
+		p.DrawingSet = *req.DrawingSet
+	}
+	if req.DrawingRevision != nil {
+		p.DrawingRevision = *req.DrawingRevision
+	}
+	if req.Settings != nil {
+		p.Settings = datatypes.NewJSONType(*req.Settings)
+	}
+	if err := validateProject(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.GetDB().Save(&p).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// DELETE /api/v1/projects/:project
+// 还有任何数据（包括已软删除的设备、文件）引用这个项目时不允许删除，返回 409 和各表的行数
+func DeleteProject(c *gin.Context) {
+	name := c.Param("project")
+
+	refs := map[string]int64{}
+	for _, ref := range db.ProjectReferences {
+		var n int64
+		if err := db.GetDB().Unscoped().Model(ref.Model).Where("project = ?", name).Count(&n).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if n > 0 {
+			refs[ref.Table] = n
+		}
+	}
+	if len(refs) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "project is still referenced", "references": refs})
+		return
+	}
+
+	res := db.GetDB().Delete(&models.Project{}, "name = ?", name)
+	if res.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func validateProject(p *models.Project) error {
+	if !slices.Contains(projectStatuses, p.Status) {
+		return errors.New("status must be one of active, on_hold, completed, archived")
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return errors.New("invalid timezone: " + p.Timezone)
+	}
+	for level := range p.Settings.Data().CxRequirements {
+		if !slices.Contains(models.CxLevels, level) {
+			return errors.New("cx_requirements: unknown level " + level)
+		}
+	}
+	if gate := p.Settings.Data().EnergizationGate; gate != nil {
+		for _, check := range gate.Checks {
+			if !slices.Contains(energizationChecks, check) {
+				return errors.New("energization_gate: unknown check " + check)
+			}
+		}
+	}
+	return nil
+}
+
+// projectExists 设备 / 文件写入前检查项目是否存在，避免拼错名字时悄悄多出一个“项目”
+func projectExists(name string) (bool, error) {
+	if name == "" {
+		return false, nil
+	}
+	var n int64
+	if err := db.GetDB().Model(&models.Project{}).Where("name = ?", name).Count(&n).Error; err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// loadProjectSettings 读项目配置，没配的字段用默认值补上
+func loadProjectSettings(name string) models.ProjectSettings {
+	var p models.Project
+	var s models.ProjectSettings
+	if err := db.GetDB().Select("name, settings").First(&p, "name = ?", name).Error; err == nil {
+		s = p.Settings.Data()
+	}
+	if len(s.EquipmentSubjects) == 0 {
+		s.EquipmentSubjects = defaultEquipmentSubjects
+	}
+	if len(s.PropagationSubjects) == 0 {
+		s.PropagationSubjects = defaultPropagationSubjects
+	}
+	reqs := make(map[string]models.CxRequirement, len(models.CxLevels))
+	for _, level := range models.CxLevels {
+		if r, ok := s.CxRequirements[level]; ok {
+			reqs[level] = r
+		} else {
+			reqs[level] = defaultCxRequirements[level]
+		}
+	}
+	s.CxRequirements = reqs
+
+	gate := models.EnergizationGate{}
+	if s.EnergizationGate != nil {
+		gate = *s.EnergizationGate
+	}
+	if len(gate.Checks) == 0 {
+		gate.Checks = energizationChecks
+	}
+	if len(gate.RequiredFileTypes) == 0 {
+		gate.RequiredFileTypes = defaultGateFileTypes
+	}
+	if len(gate.OverrideRoles) == 0 {
+		gate.OverrideRoles = defaultGateOverrideRoles
+	}
+	s.EnergizationGate = &gate
+	return s
+}
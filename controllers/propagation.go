@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"slices"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// powerState 项目里设备通电状态的内存快照。传播规则只在这里实现一次：
+// 真正修改时把 changes 写回数据库，模拟时只返回 changes
+type powerState struct {
+	project string
+	devices map[string]*models.Device
+	cat     *subjectCatalog
+	lines   []*models.Device // 所有连线（connector 分类，即 PolyLine 及其别名）
+	locked  map[string]bool  // 被上锁挂牌锁住的设备 / PolyLine，不会被置为 true
+
+	changes map[string]map[string]bool // field -> id -> 新值
+}
+
+// loadPowerState 读出项目所有设备的 id / subject / from / to 和两个状态字段
+func loadPowerState(project string) (*powerState, error) {
+	var list []models.Device
+	if err := db.GetDB().
+		Select("id", "project", "text", "subject", "energized", "energized_today", "from", "to", "source_role", "active_source", "position").
+		Where("project = ?", project).
+		Find(&list).Error; err != nil {
+		return nil, err
+	}
+	locked, err := loadLockedBranches(project)
+	if err != nil {
+		return nil, err
+	}
+	cat, err := loadSubjectCatalog(project)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &powerState{
+		project: project,
+		cat:     cat,
+		devices: make(map[string]*models.Device, len(list)),
+		locked:  map[string]bool{},
+		changes: map[string]map[string]bool{},
+	}
+	for i := range list {
+		d := &list[i]
+		s.devices[d.ID] = d
+		if cat.Category(d.Subject) == models.SubjectConnector {
+			s.lines = append(s.lines, d)
+		}
+	}
+	for _, id := range locked.IDs() {
+		s.locked[id] = true
+	}
+	return s, nil
+}
+
+func (s *powerState) get(id, field string) bool {
+	d := s.devices[id]
+	if d == nil {
+		return false
+	}
+	if field == "energized_today" {
+		return d.EnergizedToday
+	}
+	return d.Energized
+}
+
+// set 改内存里的状态并记下变化；被锁住的 id 不会被置为 true
+func (s *powerState) set(id, field string, value bool) {
+	d := s.devices[id]
+	if d == nil || (value && s.locked[id]) || s.get(id, field) == value {
+		return
+	}
+	if field == "energized_today" {
+		d.EnergizedToday = value
+	} else {
+		d.Energized = value
+	}
+	if s.changes[field] == nil {
+		s.changes[field] = map[string]bool{}
+	}
+	// 改回了原值就不算变化
+	if _, ok := s.changes[field][id]; ok {
+		delete(s.changes[field], id)
+		return
+	}
+	s.changes[field][id] = value
+}
+
+// propagate 把 panel 的某个布尔字段（energized / energized_today）传播到相关 PolyLine 和 Bus
+// （按项目 subject 目录的 connector / bus 分类识别，别名同样适用）：
+//
+// 1）先把以这些 panel 为终点的 PolyLine 的同名字段改为 value
+// 2）找到所有 “from = Bus、to = 这些 panel” 的 PolyLine，得到受影响的 Bus 列表
+// 3）对每个受影响的 Bus：
+//   - 找出所有 from = 该 Bus 的 PolyLine（即 bus → panel 的所有连线），拿到所有下游 panel
+//   - 只要这些 panel 中有任意一个 field = true，则该 Bus 的 field = true
+//     只有当所有这些 panel 的 field = false 时，Bus 的 field = false
+//   - 以该 Bus 为终点的 PolyLine（上游馈线）跟 Bus 保持一致
+//
+// 多电源：ATS / 双端开关柜只有当前供电那一路进线（见 models.SourceActive）参与上面的规则，
+// 其余进线不去改；Bus 通过闭合的联络开关给另一段母线供电时，那段母线变化后也会重新聚合这个 Bus。
+//
+// 不去动 from = bus 的 PolyLine（bus → panels），避免一个 panel 变化把所有出线全点亮。
+// panel 自己的状态由调用方先改好
+func (s *powerState) propagate(field string, panelIDs []string, value bool) {
+	panels := map[string]bool{}
+	for _, id := range panelIDs {
+		panels[id] = true
+	}
+
+	var affectedBuses []string
+	addBus := func(id string) {
+		if b := s.devices[id]; b != nil && s.cat.Category(b.Subject) == models.SubjectBus && !slices.Contains(affectedBuses, b.ID) {
+			affectedBuses = append(affectedBuses, b.ID)
+		}
+	}
+	for _, l := range s.lines {
+		if !panels[l.To] {
+			continue
+		}
+		if s.active(l) {
+			s.set(l.ID, field, value)
+		}
+		// 切换电源后原来那一路的母线也要重新聚合
+		addBus(l.From)
+	}
+
+	for i := 0; i < len(affectedBuses); i++ {
+		busID := affectedBuses[i]
+		busValue, hasDown := false, false
+		for _, l := range s.lines {
+			if l.From != busID {
+				continue
+			}
+			hasDown = true
+			if s.active(l) && s.get(l.To, field) {
+				busValue = true
+			}
+		}
+		if !hasDown {
+			continue
+		}
+		changed := s.get(busID, field) != busValue
+		s.set(busID, field, busValue)
+		for _, l := range s.lines {
+			if l.To != busID || !s.active(l) {
+				continue
+			}
+			s.set(l.ID, field, busValue)
+			if changed && l.SourceRole == models.SourceTie {
+				addBus(l.From)
+			}
+		}
+	}
+}
+
+// active 进线当前是否在给下游供电：上游开关要闭合，多电源设备要是当前那一路
+func (s *powerState) active(l *models.Device) bool {
+	if from := s.devices[l.From]; from != nil && !models.PositionConducts(from.Position) {
+		return false
+	}
+	to := s.devices[l.To]
+	if to == nil {
+		return true
+	}
+	return models.SourceActive(l.SourceRole, to.ActiveSource)
+}
+
+// isolate 开关分闸：失电的下游设备、它们相连的 PolyLine 以及开关的出线都置为未送电，
+// 再重新聚合相关母线。母线聚合只看下游，所以聚合后再把失电部分压回 false
+func (s *powerState) isolate(switchID string, lost []string, cat *subjectCatalog) {
+	dead := map[string]bool{}
+	var panels []string
+	for _, id := range lost {
+		dead[id] = true
+		if d := s.devices[id]; d != nil && cat.Energizable(d.Subject) {
+			panels = append(panels, id)
+		}
+	}
+	force := func() {
+		for id := range dead {
+			s.set(id, "energized", false)
+		}
+		for _, l := range s.lines {
+			if l.From == switchID || dead[l.From] || dead[l.To] {
+				s.set(l.ID, "energized", false)
+			}
+		}
+	}
+	force()
+	s.propagate("energized", panels, false)
+	force()
+}
+
+// changedIDs 某个字段变成 value 的 id，已排序
+func (s *powerState) changedIDs(field string, value bool) []string {
+	var ids []string
+	for id, v := range s.changes[field] {
+		if v == value {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// save 把 changes 写回数据库，并推送一条 device.propagated 事件
+func (s *powerState) save() error {
+	if err := s.write(db.GetDB()); err != nil {
+		return err
+	}
+	s.publish()
+	return nil
+}
+
+// write 只把 changes 写进 tx，要和别的修改放在同一个事务里时用，提交后再调 publish
+func (s *powerState) write(tx *gorm.DB) error {
+	for field := range s.changes {
+		for _, value := range []bool{true, false} {
+			ids := s.changedIDs(field, value)
+			if len(ids) == 0 {
+				continue
+			}
+			if err := tx.Model(&models.Device{}).Where("id IN ?", ids).Update(field, value).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *powerState) publish() {
+	if len(s.changes["energized"])+len(s.changes["energized_today"]) > 0 {
+		publishEvent(s.project, models.EventDevicePropagated, "", s.changes, "")
+	}
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+)
+
+var energizationChecks = []string{models.GateTestReports, models.GateUpstreamEnergized, models.GateChecklists, models.GateOpenIssues}
+
+// energizeOverride 强制送电请求：{"override": {"reason": "..."}}
+type energizeOverride struct {
+	Reason string `json:"reason"`
+}
+
+// checkEnergizeReadiness 按项目的送电许可规则检查设备，返回没满足的条件
+func checkEnergizeReadiness(dev *models.Device, gate *models.EnergizationGate) ([]unmetPrerequisite, error) {
+	var unmet []unmetPrerequisite
+
+	if slices.Contains(gate.Checks, models.GateTestReports) {
+		missing, err := missingFileTypes(dev.ID, gate.RequiredFileTypes)
+		if err != nil {
+			return nil, err
+		}
+		unmet = append(unmet, missing...)
+	}
+
+	if slices.Contains(gate.Checks, models.GateUpstreamEnergized) {
+		t, err := loadTopology(dev.Project)
+		if err != nil {
+			return nil, err
+		}
+		// 双电源（ATS 等）只要有一路上游送电即可；没有上游的是电源本身。
+		// 经过断开的开关的那一路不算
+		parents := t.Parents(dev.ID)
+		live := false
+		for _, e := range t.Up[dev.ID] {
+			if t.Nodes[e.From].Energized && !e.Open {
+				live = true
+				break
+			}
+		}
+		if len(parents) > 0 && !live {
+			names := make([]string, 0, len(parents))
+			for _, p := range parents {
+				names = append(names, deviceLabel(t.Nodes[p]))
+			}
+			unmet = append(unmet, unmetPrerequisite{Code: "upstream_not_energized", Detail: "upstream not energized: " + strings.Join(names, ", ")})
+		}
+	}
+
+	if slices.Contains(gate.Checks, models.GateChecklists) {
+		var lists []models.Checklist
+		if err := db.GetDB().Where("device_id = ?", dev.ID).Find(&lists).Error; err != nil {
+			return nil, err
+		}
+		if len(lists) == 0 {
+			unmet = append(unmet, unmetPrerequisite{Code: "missing_checklist", Detail: "no checklist"})
+		}
+		unmet = append(unmet, checklistUnmet(lists)...)
+	}
+
+	if slices.Contains(gate.Checks, models.GateOpenIssues) {
+		open, err := openBlockingIssues(dev.ID)
+		if err != nil {
+			return nil, err
+		}
+		unmet = append(unmet, open...)
+	}
+	return unmet, nil
+}
+
+// deviceLabel 错误信息里显示的设备名：text (id)
+func deviceLabel(d *models.Device) string {
+	if d.Text == "" {
+		return d.ID
+	}
+	return fmt.Sprintf("%s (%s)", d.Text, d.ID)
+}
+
+// gateApplies 连线、母线、建筑线的状态由传播决定，不走送电许可
+func gateApplies(cat *subjectCatalog, subject string) bool {
+	switch cat.Category(subject) {
+	case models.SubjectConnector, models.SubjectBus, models.SubjectArchitectural:
+		return false
+	}
+	return true
+}
+
+// enforceEnergizationGate 设备要从未送电改成送电时执行送电许可检查。
+// 不通过且没有有效的 override 时已经写好 409 / 400 / 401 / 403，返回 ok=false；
+// override 需要登录且有项目配置的角色；
+// 用 override 放行时返回待写入的审计记录（调用方在更新成功后保存）
+func enforceEnergizationGate(c *gin.Context, dev *models.Device, override *energizeOverride) (audit *models.EnergizationOverride, ok bool) {
+	gate := loadProjectSettings(dev.Project).EnergizationGate
+	if !gate.Enabled || dev.Energized {
+		return nil, true
+	}
+	cat, err := loadSubjectCatalog(dev.Project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !gateApplies(cat, dev.Subject) {
+		return nil, true
+	}
+
+	unmet, err := checkEnergizeReadiness(dev, gate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if len(unmet) == 0 {
+		return nil, true
+	}
+
+	if override == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "device is not ready to energize", "unmet": unmet})
+		return nil, false
+	}
+	reason := strings.TrimSpace(override.Reason)
+	if reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "override reason is required"})
+		return nil, false
+	}
+	// override 要留下是谁放行的，必须登录
+	upn, oid, ok := requireUser(c)
+	if !ok {
+		return nil, false
+	}
+	if !requestHasRole(c, gate.OverrideRoles) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "override requires role " + strings.Join(gate.OverrideRoles, " or "), "unmet": unmet})
+		return nil, false
+	}
+
+	raw, _ := json.Marshal(unmet)
+	return &models.EnergizationOverride{
+		Project:         dev.Project,
+		DeviceID:        dev.ID,
+		Reason:          reason,
+		Unmet:           datatypes.JSON(raw),
+		OverriddenBy:    upn,
+		OverriddenByOID: oid,
+	}, true
+}
+
+// GET /api/v1/devices/:id/readiness
+// 不管项目是否启用送电许可，都按规则检查一遍，方便现场提前确认
+func GetDeviceReadiness(c *gin.Context) {
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	gate := loadProjectSettings(dev.Project).EnergizationGate
+	unmet, err := checkEnergizeReadiness(&dev, gate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 被锁住的支路永远不算可送电，也不能 override
+	branches, err := loadLockedBranches(dev.Project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	locks := branches.Locks(dev.ID)
+	for _, l := range locks {
+		unmet = append(unmet, unmetPrerequisite{Code: "locked_out", Detail: fmt.Sprintf("lock %s held by %s on %s", l.LockID, l.Holder, l.DeviceID)})
+	}
+
+	var overrides []models.EnergizationOverride
+	if err := db.GetDB().Where("device_id = ?", dev.ID).Order("created_at DESC").Find(&overrides).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":    dev.ID,
+		"energized":    dev.Energized,
+		"gate_enabled": gate.Enabled,
+		"checks":       gate.Checks,
+		"ready":        len(unmet) == 0,
+		"unmet":        unmet,
+		"locks":        locks,
+		"overrides":    overrides,
+	})
+}
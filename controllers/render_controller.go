@@ -0,0 +1,338 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// 渲染时按状态上色
+const (
+	statusEnergized      = "energized"
+	statusEnergizedToday = "energized_today"
+	statusUpcoming       = "upcoming"
+	statusDeEnergized    = "de_energized"
+	statusLockedOut      = "locked_out"
+	statusArchitectural  = "architectural"
+)
+
+type markupStyle struct {
+	Label string
+	Color string
+}
+
+// 图例顺序即这里的顺序
+var markupStatusOrder = []string{statusEnergized, statusEnergizedToday, statusUpcoming, statusLockedOut, statusDeEnergized}
+
+var markupStyles = map[string]markupStyle{
+	statusEnergized:      {Label: "Energized", Color: "#d62728"},
+	statusEnergizedToday: {Label: "Energized today", Color: "#ff7f0e"},
+	statusUpcoming:       {Label: "Upcoming energization", Color: "#e6b800"},
+	statusDeEnergized:    {Label: "De-energized", Color: "#2ca02c"},
+	statusLockedOut:      {Label: "Locked out", Color: "#1f3fbf"},
+	statusArchitectural:  {Label: "", Color: "#b0b0b0"},
+}
+
+// markupShape 一个待绘制的标注，SVG 和 PDF 共用
+type markupShape struct {
+	Status string
+	Rect   [4]float64       // x1, y1, x2, y2
+	Points []models.PointPX // PolyLine 的折线点，为空时画矩形
+	Filled bool             // 细长的线状标注（Bus、Wall 等）直接填充
+	Label  string
+}
+
+type pageRender struct {
+	Project string
+	Page    int
+	Width   float64
+	Height  float64
+	Shapes  []markupShape
+	Counts  map[string]int
+	Labels  bool
+	Horizon int
+	Sheet   string // 图号，页面登记过才有
+}
+
+// GET /api/v1/projects/:project/pages/:page/render.svg?labels=1&days=7
+func RenderPageSVG(c *gin.Context) {
+	r, ok := loadPageRender(c)
+	if !ok {
+		return
+	}
+	c.Data(http.StatusOK, "image/svg+xml; charset=utf-8", []byte(r.svg()))
+}
+
+// GET /api/v1/projects/:project/pages/:page/render.pdf?labels=1&days=7
+func RenderPagePDF(c *gin.Context) {
+	r, ok := loadPageRender(c)
+	if !ok {
+		return
+	}
+	pdf := r.pdf()
+	if err := pdf.Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	filename := fmt.Sprintf("%s_page%d_%s.pdf", r.Project, r.Page, time.Now().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename))
+	c.Header("Content-Type", "application/pdf")
+	_ = pdf.Output(c.Writer)
+}
+
+// loadPageRender 解析参数、查出该页所有标注并转换成 markupShape；出错时已经写好响应
+func loadPageRender(c *gin.Context) (*pageRender, bool) {
+	project := c.Param("project")
+	page, err := strconv.Atoi(c.Param("page"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		return nil, false
+	}
+
+	// upcoming：will_energized_at 在未来 days 天内
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days < 0 {
+		days = 7
+	}
+	labels := c.Query("labels") == "1" || c.Query("labels") == "true"
+
+	var devices []models.Device
+	if err := db.GetDB().
+		Where("project = ? AND file_page = ?", project, page).
+		Find(&devices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if len(devices) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no markups on this page"})
+		return nil, false
+	}
+
+	cat, err := loadSubjectCatalog(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	locked, err := loadLockedBranches(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	r := &pageRender{
+		Project: project,
+		Page:    page,
+		Counts:  map[string]int{},
+		Labels:  labels,
+		Horizon: days,
+	}
+	now := time.Now()
+	for _, d := range devices {
+		s, ok := deviceMarkupShape(d, cat, now, time.Duration(days)*24*time.Hour)
+		if !ok {
+			continue
+		}
+		// 被锁住的支路不能显示成即将送电
+		if (s.Status == statusUpcoming || s.Status == statusDeEnergized) && len(locked.Locks(d.ID)) > 0 {
+			s.Status = statusLockedOut
+		}
+		r.Width = math.Max(r.Width, s.Rect[2])
+		r.Height = math.Max(r.Height, s.Rect[3])
+		if s.Status != statusArchitectural && cat.Category(d.Subject) != models.SubjectConnector {
+			r.Counts[s.Status]++
+		}
+		r.Shapes = append(r.Shapes, s)
+	}
+
+	// 页面登记了尺寸就用页面尺寸作画布，否则按标注范围留一点边距
+	if p := loadPage(project, page); p != nil && p.WidthPX > 0 && p.HeightPX > 0 {
+		r.Width, r.Height = float64(p.WidthPX), float64(p.HeightPX)
+		r.Sheet = p.SheetNumber
+	} else {
+		r.Width += 50
+		r.Height += 50
+	}
+	return r, true
+}
+
+// markupStatus 按 energized > energized_today > 即将送电 > 未送电 的优先级判断状态；
+// 墙、房间线、楼层线等建筑类标注只画底图，不参与状态上色
+func markupStatus(d models.Device, cat *subjectCatalog, now time.Time, horizon time.Duration) string {
+	switch {
+	case cat.Category(d.Subject) == models.SubjectArchitectural:
+		return statusArchitectural
+	case d.Energized:
+		return statusEnergized
+	case d.EnergizedToday:
+		return statusEnergizedToday
+	case d.WillEnergizedAt != nil && !d.WillEnergizedAt.Before(now) && d.WillEnergizedAt.Sub(now) <= horizon:
+		return statusUpcoming
+	default:
+		return statusDeEnergized
+	}
+}
+
+func deviceMarkupShape(d models.Device, cat *subjectCatalog, now time.Time, horizon time.Duration) (markupShape, bool) {
+	if len(d.RectPX) != 4 {
+		return markupShape{}, false
+	}
+	s := markupShape{
+		Status: markupStatus(d, cat, now, horizon),
+		Rect:   [4]float64{float64(d.RectPX[0]), float64(d.RectPX[1]), float64(d.RectPX[2]), float64(d.RectPX[3])},
+	}
+	switch {
+	case s.Status == statusArchitectural:
+		s.Label = d.Comments // 房间名 / 楼层名写在 comments 里
+	default:
+		s.Label = d.Text
+	}
+
+	if cat.Category(d.Subject) == models.SubjectConnector && len(d.PolygonPointsPX) >= 2 {
+		s.Points = d.PolygonPointsPX
+		return s, true
+	}
+
+	// 宽或高很窄的矩形是线状标注（Bus、Bus Duct、Wall…），直接填充
+	w, h := s.Rect[2]-s.Rect[0], s.Rect[3]-s.Rect[1]
+	s.Filled = math.Min(w, h) <= 25
+	return s, true
+}
+
+func (r *pageRender) svg() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f">`+"\n",
+		r.Width, r.Height, r.Width, r.Height)
+	fmt.Fprintf(&b, `<rect x="0" y="0" width="%.0f" height="%.0f" fill="#ffffff"/>`+"\n", r.Width, r.Height)
+
+	// 先画建筑底图，再画设备，保证设备在上层
+	for _, pass := range []bool{true, false} {
+		for _, s := range r.Shapes {
+			if (s.Status == statusArchitectural) != pass {
+				continue
+			}
+			color := markupStyles[s.Status].Color
+			switch {
+			case len(s.Points) > 0:
+				pts := make([]string, len(s.Points))
+				for i, p := range s.Points {
+					pts[i] = fmt.Sprintf("%.1f,%.1f", p[0], p[1])
+				}
+				fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="4"/>`+"\n", strings.Join(pts, " "), color)
+			case s.Filled:
+				fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`+"\n",
+					s.Rect[0], s.Rect[1], s.Rect[2]-s.Rect[0], s.Rect[3]-s.Rect[1], color)
+			default:
+				fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s" fill-opacity="0.25" stroke="%s" stroke-width="3"/>`+"\n",
+					s.Rect[0], s.Rect[1], s.Rect[2]-s.Rect[0], s.Rect[3]-s.Rect[1], color, color)
+			}
+			if r.Labels && s.Label != "" && len(s.Points) == 0 {
+				fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-family="Arial, sans-serif" font-size="14" fill="#000000">%s</text>`+"\n",
+					s.Rect[0], s.Rect[1]-4, html.EscapeString(s.Label))
+			}
+		}
+	}
+
+	// 图例
+	fmt.Fprintf(&b, `<g font-family="Arial, sans-serif" font-size="20">`+"\n")
+	fmt.Fprintf(&b, `<rect x="10" y="10" width="360" height="%d" fill="#ffffff" stroke="#000000"/>`+"\n", 50+30*len(markupStatusOrder))
+	fmt.Fprintf(&b, `<text x="20" y="38" font-weight="bold">%s</text>`+"\n", html.EscapeString(r.title()))
+	for i, st := range markupStatusOrder {
+		y := 52 + 30*i
+		fmt.Fprintf(&b, `<rect x="20" y="%d" width="20" height="20" fill="%s"/>`+"\n", y, markupStyles[st].Color)
+		fmt.Fprintf(&b, `<text x="50" y="%d">%s (%d)</text>`+"\n", y+17, html.EscapeString(r.legendLabel(st)), r.Counts[st])
+	}
+	b.WriteString("</g>\n</svg>\n")
+	return b.String()
+}
+
+func (r *pageRender) pdf() *gofpdf.Fpdf {
+	// 用 "P" 加真实宽高；"L" 会让 gofpdf 把 Wd / Ht 对调，横向图纸被裁掉
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		OrientationStr: "P",
+		UnitStr:        "pt",
+		Size:           gofpdf.SizeType{Wd: r.Width, Ht: r.Height},
+	})
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+
+	for _, pass := range []bool{true, false} {
+		for _, s := range r.Shapes {
+			if (s.Status == statusArchitectural) != pass {
+				continue
+			}
+			cr, cg, cb := hexToRGB(markupStyles[s.Status].Color)
+			pdf.SetDrawColor(cr, cg, cb)
+			pdf.SetFillColor(cr, cg, cb)
+			switch {
+			case len(s.Points) > 0:
+				pdf.SetLineWidth(4)
+				for i := 1; i < len(s.Points); i++ {
+					pdf.Line(s.Points[i-1][0], s.Points[i-1][1], s.Points[i][0], s.Points[i][1])
+				}
+			case s.Filled:
+				pdf.Rect(s.Rect[0], s.Rect[1], s.Rect[2]-s.Rect[0], s.Rect[3]-s.Rect[1], "F")
+			default:
+				pdf.SetLineWidth(3)
+				pdf.SetAlpha(0.25, "Normal")
+				pdf.Rect(s.Rect[0], s.Rect[1], s.Rect[2]-s.Rect[0], s.Rect[3]-s.Rect[1], "F")
+				pdf.SetAlpha(1, "Normal")
+				pdf.Rect(s.Rect[0], s.Rect[1], s.Rect[2]-s.Rect[0], s.Rect[3]-s.Rect[1], "D")
+			}
+			if r.Labels && s.Label != "" && len(s.Points) == 0 {
+				pdf.SetFont("Helvetica", "", 14)
+				pdf.SetTextColor(0, 0, 0)
+				pdf.Text(s.Rect[0], s.Rect[1]-4, s.Label)
+			}
+		}
+	}
+
+	// 图例
+	pdf.SetLineWidth(1)
+	pdf.SetDrawColor(0, 0, 0)
+	pdf.SetFillColor(255, 255, 255)
+	pdf.Rect(10, 10, 360, float64(50+30*len(markupStatusOrder)), "FD")
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.Text(20, 38, r.title())
+	pdf.SetFont("Helvetica", "", 20)
+	for i, st := range markupStatusOrder {
+		y := float64(52 + 30*i)
+		cr, cg, cb := hexToRGB(markupStyles[st].Color)
+		pdf.SetFillColor(cr, cg, cb)
+		pdf.Rect(20, y, 20, 20, "F")
+		pdf.Text(50, y+17, fmt.Sprintf("%s (%d)", r.legendLabel(st), r.Counts[st]))
+	}
+	return pdf
+}
+
+func (r *pageRender) title() string {
+	if r.Sheet != "" {
+		return fmt.Sprintf("%s - %s - %s", r.Project, r.Sheet, time.Now().Format("2006-01-02"))
+	}
+	return fmt.Sprintf("%s - page %d - %s", r.Project, r.Page, time.Now().Format("2006-01-02"))
+}
+
+func (r *pageRender) legendLabel(status string) string {
+	if status == statusUpcoming {
+		return fmt.Sprintf("%s (%dd)", markupStyles[status].Label, r.Horizon)
+	}
+	return markupStyles[status].Label
+}
+
+func hexToRGB(hex string) (int, int, int) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(hex, "#"), 16, 32)
+	if err != nil {
+		return 0, 0, 0
+	}
+	return int(v >> 16 & 0xff), int(v >> 8 & 0xff), int(v & 0xff)
+}
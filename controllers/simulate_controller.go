@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/models"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// simulateChange 一条假设的状态修改，和 PUT /devices/:id 的同名字段一致
+type simulateChange struct {
+	DeviceID       string `json:"device_id"`
+	Energized      *bool  `json:"energized"`
+	EnergizedToday *bool  `json:"energized_today"`
+	Position       string `json:"position"` // 开关设备：模拟分合闸
+}
+
+// simulatedEffect 模拟后会改变的一个设备 / PolyLine
+type simulatedEffect struct {
+	ID        string `json:"id"`
+	Text      string `json:"text,omitempty"`
+	Subject   string `json:"subject"`
+	Field     string `json:"field"` // energized / energized_today
+	Value     bool   `json:"value"`
+	Requested bool   `json:"requested"` // 请求里直接改的设备，false 表示是传播出来的
+}
+
+// POST /api/v1/projects/:project/simulate
+// 请求体：{"changes": [{"device_id": "...", "energized": false}, {"device_id": "...", "position": "open"}, ...]}
+// 按顺序在内存里执行这些修改和传播，返回会变化的设备和 PolyLine，不写数据库。
+// position 和 POST /devices/:id/operate 一样，分闸后下游没有其他电源的部分会失电。
+// 落在被锁住支路上的送电会和真实修改一样被拒绝，列在 rejected 里
+func SimulateEnergization(c *gin.Context) {
+	project := c.Param("project")
+
+	var req struct {
+		Changes []simulateChange `json:"changes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Changes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "changes must not be empty"})
+		return
+	}
+
+	s, err := loadPowerState(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	cat, err := loadSubjectCatalog(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var errs []string
+	for i, ch := range req.Changes {
+		ch.DeviceID = strings.TrimSpace(ch.DeviceID)
+		switch {
+		case s.devices[ch.DeviceID] == nil:
+			errs = append(errs, fmt.Sprintf("change %d: device %q not found in project", i+1, ch.DeviceID))
+		case ch.Energized == nil && ch.EnergizedToday == nil && ch.Position == "":
+			errs = append(errs, fmt.Sprintf("change %d: energized, energized_today or position is required", i+1))
+		case ch.Position != "" && !slices.Contains(models.Positions, ch.Position):
+			errs = append(errs, fmt.Sprintf("change %d: position must be one of closed, open, racked_out, tripped", i+1))
+		case ch.Position != "" && cat.Category(s.devices[ch.DeviceID].Subject) != models.SubjectSwitching:
+			errs = append(errs, fmt.Sprintf("change %d: only switching devices have a position", i+1))
+		}
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid changes", "errors": errs})
+		return
+	}
+
+	// 有分合闸时才需要拓扑
+	var t *topology
+	requested := map[string]bool{}
+	var rejected []gin.H
+	for _, ch := range req.Changes {
+		dev := s.devices[strings.TrimSpace(ch.DeviceID)]
+		if ch.Position != "" && ch.Position != dev.Position {
+			if t == nil {
+				if t, err = loadTopology(project); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			if ch.Position == models.PositionClosed && slices.ContainsFunc(append([]string{dev.ID}, t.Downstream(dev.ID)...), func(id string) bool { return s.locked[id] }) {
+				rejected = append(rejected, gin.H{"device_id": dev.ID, "field": "position", "code": "locked_out", "detail": "cannot close into a locked-out branch"})
+				continue
+			}
+			var lost []string
+			if !models.PositionConducts(ch.Position) && models.PositionConducts(dev.Position) {
+				lost, _ = outageImpact(t, dev.ID)
+			}
+			t.setPosition(dev.ID, ch.Position)
+			dev.Position = ch.Position
+			if len(lost) > 0 {
+				s.isolate(dev.ID, lost, cat)
+			}
+		}
+		for _, f := range []struct {
+			name  string
+			value *bool
+		}{{"energized", ch.Energized}, {"energized_today", ch.EnergizedToday}} {
+			if f.value == nil {
+				continue
+			}
+			if *f.value && s.locked[dev.ID] {
+				rejected = append(rejected, gin.H{"device_id": dev.ID, "field": f.name, "code": "locked_out", "detail": "device is in a locked-out branch"})
+				continue
+			}
+			requested[f.name+"|"+dev.ID] = true
+			s.set(dev.ID, f.name, *f.value)
+			if cat.Energizable(dev.Subject) {
+				s.propagate(f.name, []string{dev.ID}, *f.value)
+			}
+		}
+	}
+
+	var devices, lines []simulatedEffect
+	summary := map[string]int{"energized": 0, "de_energized": 0, "energized_today": 0, "not_energized_today": 0}
+	for field, byID := range s.changes {
+		for id, v := range byID {
+			d := s.devices[id]
+			e := simulatedEffect{ID: id, Text: d.Text, Subject: d.Subject, Field: field, Value: v, Requested: requested[field+"|"+id]}
+			if cat.Category(d.Subject) == models.SubjectConnector {
+				lines = append(lines, e)
+			} else {
+				devices = append(devices, e)
+			}
+			switch {
+			case field == "energized" && v:
+				summary["energized"]++
+			case field == "energized":
+				summary["de_energized"]++
+			case v:
+				summary["energized_today"]++
+			default:
+				summary["not_energized_today"]++
+			}
+		}
+	}
+	for _, list := range [][]simulatedEffect{devices, lines} {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Field != list[j].Field {
+				return list[i].Field < list[j].Field
+			}
+			return list[i].ID < list[j].ID
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project":   project,
+		"summary":   summary,
+		"devices":   devices,
+		"polylines": lines,
+		"rejected":  rejected,
+	})
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// validateSourceRole PolyLine 的 source_role 只能是 normal / emergency / tie 或空
+func validateSourceRole(role string) error {
+	if role != "" && !slices.Contains(models.SourceRoles, role) {
+		return errors.New("source_role must be one of normal, emergency, tie or empty")
+	}
+	return nil
+}
+
+// GET /api/v1/devices/:id/sources
+// 设备的所有进线、各自的角色和上游状态，当前电源以及切换记录
+func GetDeviceSources(c *gin.Context) {
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+	t, err := loadTopology(dev.Project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	type sourceInput struct {
+		Via               string `json:"via"` // PolyLine id 或 circuit:<id>
+		Role              string `json:"role,omitempty"`
+		Active            bool   `json:"active"`
+		UpstreamID        string `json:"upstream_id"`
+		UpstreamText      string `json:"upstream_text"`
+		UpstreamEnergized bool   `json:"upstream_energized"`
+	}
+	inputs := make([]sourceInput, 0, len(t.Up[dev.ID]))
+	for _, e := range t.Up[dev.ID] {
+		up := t.Nodes[e.From]
+		inputs = append(inputs, sourceInput{Via: e.Via, Role: e.Role, Active: e.Active, UpstreamID: up.ID, UpstreamText: up.Text, UpstreamEnergized: up.Energized})
+	}
+
+	var history []models.SourceTransfer
+	if err := db.GetDB().Where("device_id = ?", dev.ID).Order("created_at DESC, id DESC").Find(&history).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	active := dev.ActiveSource
+	if active == "" {
+		active = models.SourceNormal
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":     dev.ID,
+		"active_source": active,
+		"inputs":        inputs,
+		"transfers":     history,
+	})
+}
+
+// POST /api/v1/devices/:id/sources/transfer
+// 请求体：{"active_source": "emergency", "notes": "utility outage"}
+// 设备必须有这个角色的进线。切换后按设备当前状态重新传播，两路上游母线都会重新聚合。
+// 切换会改变送电范围，要记录操作人，必须登录
+func TransferDeviceSource(c *gin.Context) {
+	var req struct {
+		ActiveSource string `json:"active_source"`
+		Notes        string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	upn, oid, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	req.ActiveSource = strings.ToLower(strings.TrimSpace(req.ActiveSource))
+	if !slices.Contains(models.SourceRoles, req.ActiveSource) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "active_source must be one of normal, emergency, tie"})
+		return
+	}
+
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+	from := dev.ActiveSource
+	if from == "" {
+		from = models.SourceNormal
+	}
+	if from == req.ActiveSource {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device is already on " + from})
+		return
+	}
+
+	cat, err := loadSubjectCatalog(dev.Project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var n int64
+	if err := db.GetDB().Model(&models.Device{}).
+		Where("project = ? AND LOWER(subject) IN ? AND \"to\" = ? AND source_role = ?", dev.Project, cat.MatchKeys(models.SubjectConnector), dev.ID, req.ActiveSource).
+		Count(&n).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("device has no %s input", req.ActiveSource)})
+		return
+	}
+
+	t := models.SourceTransfer{
+		Project:      dev.Project,
+		DeviceID:     dev.ID,
+		FromSource:   from,
+		ToSource:     req.ActiveSource,
+		Notes:        strings.TrimSpace(req.Notes),
+		ChangedBy:    upn,
+		ChangedByOID: oid,
+	}
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		// 条件里带上原电源，防止两个请求同时切换
+		res := tx.Model(&models.Device{}).
+			Where("id = ? AND COALESCE(active_source, '') = ?", dev.ID, dev.ActiveSource).
+			Update("active_source", req.ActiveSource)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errSourceChanged
+		}
+		return tx.Create(&t).Error
+	})
+	if errors.Is(err, errSourceChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	_ = setConnectedPolylinesEnergized([]string{dev.ID}, dev.Energized)
+	_ = setConnectedPolylinesEnergizedToday([]string{dev.ID}, dev.EnergizedToday)
+	publishDeviceEvent(c, models.EventDeviceUpdated, dev.ID)
+
+	c.JSON(http.StatusCreated, t)
+}
+
+var errSourceChanged = errors.New("active source was changed by another request, reload and retry")
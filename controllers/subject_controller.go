@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+var subjectCategories = []string{models.SubjectEquipment, models.SubjectSwitching, models.SubjectConnector, models.SubjectBus, models.SubjectArchitectural}
+
+// 项目还没有自己的目录时用的默认目录（和现有数据里的写法一致）
+var defaultSubjects = []models.Subject{
+	{Name: "panel board", Aliases: pq.StringArray{"panelboard", "panel"}, Category: models.SubjectEquipment, Energizable: true, Shape: "rect"},
+	{Name: "transformer", Aliases: pq.StringArray{"xfmr"}, Category: models.SubjectEquipment, Energizable: true, Shape: "rect"},
+	{Name: "generator", Aliases: pq.StringArray{"gen"}, Category: models.SubjectEquipment, Shape: "rect"},
+	{Name: "ATS", Aliases: pq.StringArray{"automatic transfer switch"}, Category: models.SubjectEquipment, Shape: "rect"},
+	{Name: "Breaker", Category: models.SubjectSwitching, Energizable: true, Shape: "rect"},
+	{Name: "Bus Breaker", Category: models.SubjectSwitching, Energizable: true, Shape: "rect"},
+	{Name: "Bus", Category: models.SubjectBus, Shape: "line"},
+	{Name: "Bus Duct", Category: models.SubjectBus, Shape: "line"},
+	{Name: "PolyLine", Category: models.SubjectConnector, Shape: "polyline"},
+	{Name: "Wall", Category: models.SubjectArchitectural, Color: "#b0b0b0", Shape: "line"},
+	{Name: "Room Line", Category: models.SubjectArchitectural, Color: "#b0b0b0", Shape: "line"},
+	{Name: "Level Line", Category: models.SubjectArchitectural, Color: "#b0b0b0", Shape: "line"},
+}
+
+// subjectCatalog 一个项目的 subject 目录，按名字 / 别名不区分大小写查找
+type subjectCatalog struct {
+	Project string
+	Source  string // project：项目自己配置的；default：默认目录
+	Entries []models.Subject
+	byKey   map[string]int
+}
+
+func newSubjectCatalog(project, source string, entries []models.Subject) *subjectCatalog {
+	c := &subjectCatalog{Project: project, Source: source, Entries: entries, byKey: map[string]int{}}
+	for i, e := range entries {
+		c.byKey[strings.ToLower(e.Name)] = i
+		for _, a := range e.Aliases {
+			c.byKey[strings.ToLower(a)] = i
+		}
+	}
+	return c
+}
+
+// loadSubjectCatalog 读项目的 subject 目录；项目没配置时用默认目录，
+// 并按项目 settings 里的 equipment_subjects / propagation_subjects 调整
+func loadSubjectCatalog(project string) (*subjectCatalog, error) {
+	var entries []models.Subject
+	if err := db.GetDB().Where("project = ?", project).Order("sort_order, name").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return newSubjectCatalog(project, "project", entries), nil
+	}
+
+	entries = make([]models.Subject, len(defaultSubjects))
+	copy(entries, defaultSubjects)
+	for i := range entries {
+		entries[i].Project = project
+		entries[i].SortOrder = i
+	}
+	c := newSubjectCatalog(project, "default", entries)
+
+	s := loadProjectSettings(project)
+	if !slices.Equal(s.EquipmentSubjects, defaultEquipmentSubjects) {
+		for i := range c.Entries {
+			if c.Entries[i].Category == models.SubjectEquipment {
+				c.Entries[i].Category = ""
+			}
+		}
+		for _, name := range s.EquipmentSubjects {
+			c.ensure(name).Category = models.SubjectEquipment
+		}
+	}
+	if !slices.Equal(s.PropagationSubjects, defaultPropagationSubjects) {
+		for i := range c.Entries {
+			c.Entries[i].Energizable = false
+		}
+		for _, name := range s.PropagationSubjects {
+			c.ensure(name).Energizable = true
+		}
+	}
+	return c, nil
+}
+
+// ensure 找到 name 对应的条目，没有就追加一条
+func (c *subjectCatalog) ensure(name string) *models.Subject {
+	if i, ok := c.byKey[strings.ToLower(name)]; ok {
+		return &c.Entries[i]
+	}
+	c.Entries = append(c.Entries, models.Subject{Project: c.Project, Name: name, SortOrder: len(c.Entries)})
+	c.byKey[strings.ToLower(name)] = len(c.Entries) - 1
+	return &c.Entries[len(c.Entries)-1]
+}
+
+// Lookup 按名字或别名查找，不区分大小写；找不到返回 nil
+func (c *subjectCatalog) Lookup(subject string) *models.Subject {
+	if i, ok := c.byKey[strings.ToLower(strings.TrimSpace(subject))]; ok {
+		return &c.Entries[i]
+	}
+	return nil
+}
+
+func (c *subjectCatalog) Category(subject string) string {
+	if e := c.Lookup(subject); e != nil {
+		return e.Category
+	}
+	return ""
+}
+
+func (c *subjectCatalog) Energizable(subject string) bool {
+	e := c.Lookup(subject)
+	return e != nil && e.Energizable
+}
+
+// MatchKeys 某个分类下所有名字和别名（小写），配合 LOWER(subject) IN ? 使用
+func (c *subjectCatalog) MatchKeys(category string) []string {
+	var keys []string
+	for key, i := range c.byKey {
+		if c.Entries[i].Category == category {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// GET /api/v1/projects/:project/subjects
+func ListSubjects(c *gin.Context) {
+	cat, err := loadSubjectCatalog(c.Param("project"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"project": cat.Project,
+		"source":  cat.Source,
+		"count":   len(cat.Entries),
+		"data":    cat.Entries,
+	})
+}
+
+// POST /api/v1/projects/:project/subjects/seed
+// 把当前生效的（默认）目录写进项目，之后就可以逐条修改
+func SeedSubjects(c *gin.Context) {
+	project := c.Param("project")
+	if ok, err := projectExists(project); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+
+	cat, err := loadSubjectCatalog(project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if cat.Source == "project" {
+		c.JSON(http.StatusConflict, gin.H{"error": "project already has a subject catalog"})
+		return
+	}
+	if err := db.GetDB().Create(&cat.Entries).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"project": project, "count": len(cat.Entries), "data": cat.Entries})
+}
+
+// POST /api/v1/projects/:project/subjects
+func CreateSubject(c *gin.Context) {
+	project := c.Param("project")
+
+	var body models.Subject
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	body.ID = 0
+	body.Project = project
+
+	if ok, err := projectExists(project); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	if err := validateSubject(&body, 0); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.GetDB().Create(&body).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, body)
+}
+
+// PUT /api/v1/projects/:project/subjects/:id
+func UpdateSubject(c *gin.Context) {
+	type updateDTO struct {
+		Name        *string   `json:"name"`
+		Aliases     *[]string `json:"aliases"`
+		Category    *string   `json:"category"`
+		Energizable *bool     `json:"energizable"`
+		Color       *string   `json:"color"`
+		Shape       *string   `json:"shape"`
+		SortOrder   *int      `json:"sort_order"`
+	}
+	var req updateDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var s models.Subject
+	if err := db.GetDB().First(&s, "id = ? AND project = ?", c.Param("id"), c.Param("project")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "subject not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Name != nil {
+		s.Name = *req.Name
+	}
+	if req.Aliases != nil {
+		s.Aliases = pq.StringArray(*req.Aliases)
+	}
+	if req.Category != nil {
+		s.Category = *req.Category
+	}
+	if req.Energizable != nil {
+		s.Energizable = *req.Energizable
+	}
+	if req.Color != nil {
+		s.Color = *req.Color
+	}
+	if req.Shape != nil {
+		s.Shape = *req.Shape
+	}
+	if req.SortOrder != nil {
+		s.SortOrder = *req.SortOrder
+	}
+	if err := validateSubject(&s, s.ID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.GetDB().Save(&s).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// DELETE /api/v1/projects/:project/subjects/:id
+func DeleteSubject(c *gin.Context) {
+	res := db.GetDB().Delete(&models.Subject{}, "id = ? AND project = ?", c.Param("id"), c.Param("project"))
+	if res.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "subject not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// validateSubject 检查分类合法，并且名字 / 别名不和同项目其它条目冲突（不区分大小写）
+func validateSubject(s *models.Subject, selfID uint) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	if !slices.Contains(subjectCategories, s.Category) {
+		return errors.New("category must be one of equipment, switching, connector, bus, architectural")
+	}
+
+	var others []models.Subject
+	if err := db.GetDB().Where("project = ? AND id <> ?", s.Project, selfID).Find(&others).Error; err != nil {
+		return err
+	}
+	taken := newSubjectCatalog(s.Project, "project", others)
+	for _, key := range append([]string{s.Name}, s.Aliases...) {
+		if e := taken.Lookup(key); e != nil {
+			return errors.New("name or alias " + key + " is already used by subject " + e.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,588 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var switchingActions = []string{models.StepEnergize, models.StepDeEnergize, models.StepVerify}
+
+// switchingStepInput 新建 / 修改操作票时的步骤，顺序即执行顺序
+type switchingStepInput struct {
+	Action          string `json:"action"`
+	DeviceID        string `json:"device_id"`
+	Instructions    string `json:"instructions"`
+	ExpectEnergized *bool  `json:"expect_energized"`
+}
+
+type switchingPlanRequest struct {
+	Name        *string               `json:"name"`
+	Description *string               `json:"description"`
+	Steps       *[]switchingStepInput `json:"steps"`
+}
+
+// GET /api/v1/projects/:project/switching-plans?status=draft,approved
+func ListSwitchingPlans(c *gin.Context) {
+	project := c.Param("project")
+
+	q := db.GetDB().Where("project = ?", project)
+	if s := c.Query("status"); s != "" {
+		q = q.Where("status IN ?", strings.Split(s, ","))
+	}
+	var list []models.SwitchingPlan
+	if err := q.Order("created_at DESC").Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"project": project,
+		"count":   len(list),
+		"data":    list,
+	})
+}
+
+// POST /api/v1/projects/:project/switching-plans
+// 请求体：{"name": "MSB-1 energization", "steps": [{"action": "verify", "device_id": "...", "expect_energized": false},
+// {"action": "energize", "device_id": "..."}]}
+func CreateSwitchingPlan(c *gin.Context) {
+	project := c.Param("project")
+
+	var req switchingPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 编写人、审批人、执行人都要留名，必须登录
+	upn, _, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	plan := models.SwitchingPlan{Project: project, Status: models.SwitchingDraft, CreatedBy: upn}
+	if req.Name != nil {
+		plan.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		plan.Description = *req.Description
+	}
+	if plan.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if req.Steps != nil {
+		steps, err := buildSwitchingSteps(project, *req.Steps)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		plan.Steps = steps
+	}
+
+	if err := db.GetDB().Create(&plan).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, plan)
+}
+
+// GET /api/v1/switching-plans/:id
+func GetSwitchingPlan(c *gin.Context) {
+	plan, ok := findSwitchingPlan(c)
+	if !ok {
+		return
+	}
+	fillStepDevices(plan.Steps)
+	c.JSON(http.StatusOK, plan)
+}
+
+// PUT /api/v1/switching-plans/:id
+// 只能改草稿或已审批的操作票；改已审批的会退回草稿，需要重新审批。传 steps 时整体替换
+func UpdateSwitchingPlan(c *gin.Context) {
+	var req switchingPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, ok := findSwitchingPlan(c)
+	if !ok {
+		return
+	}
+	if plan.Status != models.SwitchingDraft && plan.Status != models.SwitchingApproved {
+		c.JSON(http.StatusConflict, gin.H{"error": "plan is " + plan.Status + " and can no longer be edited"})
+		return
+	}
+
+	if req.Name != nil {
+		plan.Name = strings.TrimSpace(*req.Name)
+		if plan.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name must not be empty"})
+			return
+		}
+	}
+	if req.Description != nil {
+		plan.Description = *req.Description
+	}
+	var steps []models.SwitchingStep
+	if req.Steps != nil {
+		var err error
+		if steps, err = buildSwitchingSteps(plan.Project, *req.Steps); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	prevStatus := plan.Status
+	plan.Status = models.SwitchingDraft
+	plan.ApprovedBy, plan.ApprovedAt = "", nil
+
+	err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.SwitchingPlan{}).
+			Where("id = ? AND status = ?", plan.ID, prevStatus).
+			Updates(map[string]any{"name": plan.Name, "description": plan.Description, "status": plan.Status, "approved_by": "", "approved_at": nil})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errSwitchingPlanChanged
+		}
+		if req.Steps == nil {
+			return nil
+		}
+		if err := tx.Where("plan_id = ?", plan.ID).Delete(&models.SwitchingStep{}).Error; err != nil {
+			return err
+		}
+		for i := range steps {
+			steps[i].PlanID = plan.ID
+		}
+		if len(steps) > 0 {
+			return tx.Create(&steps).Error
+		}
+		return nil
+	})
+	if errors.Is(err, errSwitchingPlanChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if plan, ok = findSwitchingPlan(c); !ok {
+		return
+	}
+	fillStepDevices(plan.Steps)
+	c.JSON(http.StatusOK, plan)
+}
+
+// DELETE /api/v1/switching-plans/:id
+// 开始执行后的操作票是现场记录，不能删除
+func DeleteSwitchingPlan(c *gin.Context) {
+	res := db.GetDB().
+		Where("id = ? AND status IN ?", c.Param("id"), []string{models.SwitchingDraft, models.SwitchingApproved}).
+		Delete(&models.SwitchingPlan{})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		var n int64
+		db.GetDB().Model(&models.SwitchingPlan{}).Where("id = ?", c.Param("id")).Count(&n)
+		if n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "switching plan not found"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "only draft or approved plans can be deleted"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /api/v1/switching-plans/:id/approve
+// 需要登录，审批人不能是编写人
+func ApproveSwitchingPlan(c *gin.Context) {
+	plan, ok := findSwitchingPlan(c)
+	if !ok {
+		return
+	}
+	if plan.Status != models.SwitchingDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": "only draft plans can be approved, plan is " + plan.Status})
+		return
+	}
+	if len(plan.Steps) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "plan has no steps"})
+		return
+	}
+	upn, _, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	if strings.EqualFold(upn, plan.CreatedBy) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "plan must be approved by someone other than its author"})
+		return
+	}
+
+	res := db.GetDB().Model(&models.SwitchingPlan{}).
+		Where("id = ? AND status = ?", plan.ID, models.SwitchingDraft).
+		Updates(map[string]any{"status": models.SwitchingApproved, "approved_by": upn, "approved_at": time.Now()})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": errSwitchingPlanChanged.Error()})
+		return
+	}
+
+	if plan, ok = findSwitchingPlan(c); !ok {
+		return
+	}
+	fillStepDevices(plan.Steps)
+	c.JSON(http.StatusOK, plan)
+}
+
+// POST /api/v1/switching-plans/:id/steps/:step/execute
+// 请求体：{"notes": "...", "override": {"reason": "..."}}
+// 只能按顺序执行下一个未执行的步骤。energize 和单独修改设备一样要过上锁检查和送电许可，
+// 执行后照常传播到相连的 PolyLine / Bus；verify 只核对设备当前状态。需要登录
+func ExecuteSwitchingStep(c *gin.Context) {
+	var req struct {
+		Notes    string            `json:"notes"`
+		Override *energizeOverride `json:"override"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, ok := findSwitchingPlan(c)
+	if !ok {
+		return
+	}
+	if plan.Status != models.SwitchingApproved && plan.Status != models.SwitchingExecuting {
+		c.JSON(http.StatusConflict, gin.H{"error": "plan is " + plan.Status + ", only approved plans can be executed"})
+		return
+	}
+
+	idx := slices.IndexFunc(plan.Steps, func(s models.SwitchingStep) bool { return fmt.Sprint(s.ID) == c.Param("step") })
+	if idx < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "step not found"})
+		return
+	}
+	step := &plan.Steps[idx]
+	if step.Status != models.StepPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "step is already " + step.Status})
+		return
+	}
+	if next := slices.IndexFunc(plan.Steps, func(s models.SwitchingStep) bool { return s.Status == models.StepPending }); next != idx {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("steps must be executed in order, next step is #%d", plan.Steps[next].Seq)})
+		return
+	}
+
+	var dev models.Device
+	if err := db.GetDB().First(&dev, "id = ?", step.DeviceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	var overrideAudit *models.EnergizationOverride
+	switch step.Action {
+	case models.StepVerify:
+		if step.ExpectEnergized != nil && dev.Energized != *step.ExpectEnergized {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("verification failed: %s energized=%t, expected %t", deviceLabel(&dev), dev.Energized, *step.ExpectEnergized)})
+			return
+		}
+	case models.StepEnergize:
+		if rejectIfLockedOut(c, &dev) {
+			return
+		}
+		if overrideAudit, ok = enforceEnergizationGate(c, &dev, req.Override); !ok {
+			return
+		}
+	}
+
+	upn, oid, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	prev := dev.Energized
+	last := idx == len(plan.Steps)-1
+	err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		planChanges := map[string]any{"status": models.SwitchingExecuting}
+		if plan.StartedAt == nil {
+			planChanges["started_at"] = now
+		}
+		if last {
+			planChanges["status"] = models.SwitchingCompleted
+			planChanges["completed_at"] = now
+		}
+		res := tx.Model(&models.SwitchingPlan{}).Where("id = ? AND status = ?", plan.ID, plan.Status).Updates(planChanges)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errSwitchingPlanChanged
+		}
+
+		stepChanges := map[string]any{
+			"status":           models.StepDone,
+			"performed_by":     upn,
+			"performed_by_oid": oid,
+			"performed_at":     now,
+			"notes":            strings.TrimSpace(req.Notes),
+			"prev_energized":   prev,
+		}
+		if step.Action != models.StepVerify {
+			if err := tx.Model(&models.Device{}).Where("id = ?", dev.ID).Update("energized", step.Action == models.StepEnergize).Error; err != nil {
+				return err
+			}
+		}
+		if overrideAudit != nil {
+			if err := tx.Create(overrideAudit).Error; err != nil {
+				return err
+			}
+			stepChanges["override_id"] = overrideAudit.ID
+		}
+		res = tx.Model(&models.SwitchingStep{}).Where("id = ? AND status = ?", step.ID, models.StepPending).Updates(stepChanges)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errSwitchingPlanChanged
+		}
+		return nil
+	})
+	if errors.Is(err, errSwitchingPlanChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if step.Action != models.StepVerify {
+		propagateSwitchedDevices(dev.Project, map[string]bool{dev.ID: step.Action == models.StepEnergize})
+		publishDeviceEvent(c, models.EventDeviceUpdated, dev.ID)
+	}
+
+	if plan, ok = findSwitchingPlan(c); !ok {
+		return
+	}
+	fillStepDevices(plan.Steps)
+	c.JSON(http.StatusOK, plan)
+}
+
+// POST /api/v1/switching-plans/:id/rollback
+// 请求体：{"notes": "..."}，必须写原因。按相反顺序把已执行的步骤恢复到执行前的状态；
+// 需要重新送电的设备如果已经被上锁，整体拒绝。需要登录
+func RollbackSwitchingPlan(c *gin.Context) {
+	var req struct {
+		Notes string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Notes = strings.TrimSpace(req.Notes)
+	if req.Notes == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "notes are required when rolling back a plan"})
+		return
+	}
+
+	plan, ok := findSwitchingPlan(c)
+	if !ok {
+		return
+	}
+	if plan.Status != models.SwitchingExecuting && plan.Status != models.SwitchingCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "only executing or completed plans can be rolled back, plan is " + plan.Status})
+		return
+	}
+
+	// 从最后一步往前恢复，同一设备多次操作时以最早一步执行前的状态为准
+	restore := map[string]bool{}
+	var done []uint
+	for i := len(plan.Steps) - 1; i >= 0; i-- {
+		s := plan.Steps[i]
+		if s.Status != models.StepDone {
+			continue
+		}
+		done = append(done, s.ID)
+		if s.Action != models.StepVerify && s.PrevEnergized != nil {
+			restore[s.DeviceID] = *s.PrevEnergized
+		}
+	}
+
+	locked, err := loadLockedBranches(plan.Project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var unmet []unmetPrerequisite
+	for id, v := range restore {
+		for _, l := range locked.Locks(id) {
+			if v {
+				unmet = append(unmet, unmetPrerequisite{Code: "locked_out", Detail: fmt.Sprintf("%s is locked by %s (%s)", id, l.LockID, l.Holder)})
+			}
+		}
+	}
+	if len(unmet) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "cannot re-energize locked-out devices", "unmet": unmet})
+		return
+	}
+
+	upn, _, ok := requireUser(c)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.SwitchingPlan{}).
+			Where("id = ? AND status = ?", plan.ID, plan.Status).
+			Updates(map[string]any{"status": models.SwitchingRolledBack, "rolled_back_at": now, "rolled_back_by": upn, "rollback_notes": req.Notes})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errSwitchingPlanChanged
+		}
+		for id, v := range restore {
+			if err := tx.Model(&models.Device{}).Where("id = ?", id).Update("energized", v).Error; err != nil {
+				return err
+			}
+		}
+		if len(done) == 0 {
+			return nil
+		}
+		return tx.Model(&models.SwitchingStep{}).Where("id IN ?", done).
+			Updates(map[string]any{"status": models.StepRolledBack, "rolled_back_at": now}).Error
+	})
+	if errors.Is(err, errSwitchingPlanChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	propagateSwitchedDevices(plan.Project, restore)
+	for id := range restore {
+		publishDeviceEvent(c, models.EventDeviceUpdated, id)
+	}
+
+	if plan, ok = findSwitchingPlan(c); !ok {
+		return
+	}
+	fillStepDevices(plan.Steps)
+	c.JSON(http.StatusOK, plan)
+}
+
+var errSwitchingPlanChanged = errors.New("switching plan was changed by another request, reload and retry")
+
+// findSwitchingPlan 按 :id 查操作票（带按顺序排好的步骤）；找不到时已经写好 404
+func findSwitchingPlan(c *gin.Context) (*models.SwitchingPlan, bool) {
+	var plan models.SwitchingPlan
+	err := db.GetDB().
+		Preload("Steps", func(tx *gorm.DB) *gorm.DB { return tx.Order("seq") }).
+		First(&plan, "id = ?", c.Param("id")).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "switching plan not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &plan, true
+}
+
+// buildSwitchingSteps 校验步骤并按顺序编号，设备必须属于同一项目
+func buildSwitchingSteps(project string, in []switchingStepInput) ([]models.SwitchingStep, error) {
+	ids := make([]string, 0, len(in))
+	for _, s := range in {
+		ids = append(ids, strings.TrimSpace(s.DeviceID))
+	}
+	var found []string
+	if len(ids) > 0 {
+		if err := db.GetDB().Model(&models.Device{}).Where("project = ? AND id IN ?", project, ids).Pluck("id", &found).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	steps := make([]models.SwitchingStep, 0, len(in))
+	for i, s := range in {
+		action := strings.ToLower(strings.TrimSpace(s.Action))
+		if !slices.Contains(switchingActions, action) {
+			return nil, fmt.Errorf("step %d: action must be one of energize, de_energize, verify", i+1)
+		}
+		if ids[i] == "" {
+			return nil, fmt.Errorf("step %d: device_id is required", i+1)
+		}
+		if !slices.Contains(found, ids[i]) {
+			return nil, fmt.Errorf("step %d: device %s not found in project %s", i+1, ids[i], project)
+		}
+		if s.ExpectEnergized != nil && action != models.StepVerify {
+			return nil, fmt.Errorf("step %d: expect_energized only applies to verify steps", i+1)
+		}
+		steps = append(steps, models.SwitchingStep{
+			Seq:             i + 1,
+			Action:          action,
+			DeviceID:        ids[i],
+			Instructions:    s.Instructions,
+			ExpectEnergized: s.ExpectEnergized,
+			Status:          models.StepPending,
+		})
+	}
+	return steps, nil
+}
+
+// fillStepDevices 补上步骤里设备的 text
+func fillStepDevices(steps []models.SwitchingStep) {
+	if len(steps) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(steps))
+	for _, s := range steps {
+		ids = append(ids, s.DeviceID)
+	}
+	var devs []models.Device
+	if err := db.GetDB().Select("id", "text").Where("id IN ?", ids).Find(&devs).Error; err != nil {
+		return
+	}
+	text := make(map[string]string, len(devs))
+	for _, d := range devs {
+		text[d.ID] = d.Text
+	}
+	for i := range steps {
+		steps[i].DeviceText = text[steps[i].DeviceID]
+	}
+}
+
+// propagateSwitchedDevices 和 UpdateDevice 一样，把可送电设备的新状态传播到相连的 PolyLine / Bus
+func propagateSwitchedDevices(project string, values map[string]bool) {
+	cat, err := loadSubjectCatalog(project)
+	if err != nil {
+		return
+	}
+	byValue := map[bool][]string{}
+	for id, v := range values {
+		var dev models.Device
+		if err := db.GetDB().Select("id", "subject").First(&dev, "id = ?", id).Error; err != nil || !cat.Energizable(dev.Subject) {
+			continue
+		}
+		byValue[v] = append(byValue[v], id)
+	}
+	// 先停电再送电，共用母线时以送电为准
+	_ = setConnectedPolylinesEnergized(byValue[false], false)
+	_ = setConnectedPolylinesEnergized(byValue[true], true)
+}
@@ -0,0 +1,308 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// 游标往回退一段时间：更新时间由写入时的应用服务器时钟决定，晚提交的事务、
+	// 多实例之间的时钟误差都可能让记录的 updated_at 落在上一次游标之前。
+	// 重叠部分会重复下发，客户端按 id 覆盖即可
+	syncCursorOverlap = 5 * time.Second
+	syncUploadLimit   = 500
+)
+
+// 离线上传单条记录的处理结果
+const (
+	syncApplied  = "applied"
+	syncConflict = "conflict" // 离线期间服务器上已被修改，带回服务器当前版本
+	syncDeleted  = "deleted"  // 离线期间服务器上已被删除
+	syncNotFound = "not_found"
+	syncRejected = "rejected" // 校验不通过、被上锁或不满足送电许可
+)
+
+// GET /api/v1/projects/:project/changes?since=<cursor>
+// 离线同步：返回 since 之后新增 / 修改的设备和文件元数据，以及被删除的设备和文件 id（墓碑）。
+// 不带 since 时返回项目当前的全部设备和文件，没有墓碑。
+// 响应里的 cursor 留给下一次请求，客户端不要自己解析
+func GetProjectChanges(c *gin.Context) {
+	project := c.Param("project")
+
+	var since time.Time
+	if s := c.Query("since"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since cursor"})
+			return
+		}
+		since = t
+	}
+	// 先定下一次的游标再查询，查询期间写入的记录下次还会再发一遍
+	next := time.Now().Add(-syncCursorOverlap)
+	if next.Before(since) {
+		next = since
+	}
+
+	devices := []models.Device{}
+	q := db.GetDB().Where("project = ?", project)
+	if !since.IsZero() {
+		q = q.Where("updated_at > ?", since)
+	}
+	if err := q.Order("updated_at, id").Find(&devices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	files := []models.DeviceFile{}
+	q = db.GetDB().Where("project = ?", project)
+	if !since.IsZero() {
+		q = q.Where("updated_at > ?", since)
+	}
+	if err := q.Order("updated_at, id").Find(&files).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	deletedDevices := []string{}
+	deletedFiles := []uint{}
+	if !since.IsZero() {
+		if err := db.GetDB().Unscoped().Model(&models.Device{}).
+			Where("project = ? AND deleted_at > ?", project, since).
+			Order("deleted_at").
+			Pluck("id", &deletedDevices).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := db.GetDB().Unscoped().Model(&models.DeviceFile{}).
+			Where("project = ? AND deleted_at > ?", project, since).
+			Order("deleted_at").
+			Pluck("id", &deletedFiles).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"cursor":          next.UTC().Format(time.RFC3339Nano),
+		"devices":         devices,
+		"files":           files,
+		"deleted_devices": deletedDevices,
+		"deleted_files":   deletedFiles,
+	})
+}
+
+// offlineEdit 离线期间对一个设备的修改。version 是客户端修改时看到的设备版本（和 ETag 同一个），
+// 服务器上的版本不一样说明别人改过，整条记录不应用
+type offlineEdit struct {
+	ID      string `json:"id"`
+	Version int64  `json:"version"`
+	Changes struct {
+		Text            *string    `json:"text"`
+		Comments        *string    `json:"comments"`
+		Energized       *bool      `json:"energized"`
+		EnergizedToday  *bool      `json:"energized_today"`
+		WillEnergizedAt *time.Time `json:"will_energized_at"`
+		// 和已有属性合并，值为 null 的键会被删除
+		Attributes map[string]any `json:"attributes"`
+	} `json:"changes"`
+}
+
+// offlineResult 一条离线修改的处理结果，device 为服务器上的当前版本
+type offlineResult struct {
+	ID     string              `json:"id"`
+	Status string              `json:"status"`
+	Error  string              `json:"error,omitempty"`
+	Unmet  []unmetPrerequisite `json:"unmet,omitempty"`
+	Device *models.Device      `json:"device,omitempty"`
+}
+
+// POST /api/v1/projects/:project/changes
+// 请求体：{"devices": [{"id": "...", "version": <离线前拿到的 version>, "changes": {"text": "..."}}]}
+// 按顺序逐条应用，每条单独成败：版本不一致返回 conflict 和服务器版本，由客户端决定覆盖还是放弃。
+// 离线修改不能 override 送电许可，也不能改 subject、几何和连线
+func UploadOfflineChanges(c *gin.Context) {
+	project := c.Param("project")
+
+	var req struct {
+		Devices []offlineEdit `json:"devices"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Devices) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no changes to upload"})
+		return
+	}
+	if len(req.Devices) > syncUploadLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many records, upload at most 500 per request"})
+		return
+	}
+
+	upn, _ := requestUser(c)
+	results := make([]offlineResult, 0, len(req.Devices))
+	counts := map[string]int{}
+	for _, e := range req.Devices {
+		r := applyOfflineEdit(project, e)
+		if r.Status == syncApplied {
+			publishEvent(project, models.EventDeviceUpdated, r.ID, r.Device, upn)
+		}
+		counts[r.Status]++
+		results = append(results, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"counts":  counts,
+		"results": results,
+	})
+}
+
+// applyOfflineEdit 应用一条离线修改。版本检查放在 UPDATE 的条件里，
+// 读出版本到写入之间被别人改了也会按冲突处理
+func applyOfflineEdit(project string, e offlineEdit) offlineResult {
+	r := offlineResult{ID: e.ID}
+	fail := func(status string, err error) offlineResult {
+		r.Status = status
+		if err != nil {
+			r.Error = err.Error()
+		}
+		return r
+	}
+
+	var dev models.Device
+	if err := db.GetDB().Unscoped().First(&dev, "id = ? AND project = ?", e.ID, project).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fail(syncNotFound, nil)
+		}
+		return fail(syncRejected, err)
+	}
+	if dev.DeletedAt.Valid {
+		return fail(syncDeleted, nil)
+	}
+	if e.Version <= 0 {
+		return fail(syncRejected, errors.New("version is required"))
+	}
+	if dev.Version != e.Version {
+		r.Device = &dev
+		return fail(syncConflict, nil)
+	}
+
+	ch := e.Changes
+	changes := map[string]any{}
+	if ch.Text != nil {
+		changes["text"] = *ch.Text
+	}
+	if ch.Comments != nil {
+		if err := checkCommentsWritable(dev.Project, dev.Subject); err != nil {
+			return fail(syncRejected, err)
+		}
+		changes["comments"] = *ch.Comments
+	}
+	if ch.Energized != nil {
+		changes["energized"] = *ch.Energized
+	}
+	if ch.EnergizedToday != nil {
+		changes["energized_today"] = *ch.EnergizedToday
+	}
+	if ch.WillEnergizedAt != nil {
+		changes["will_energized_at"] = *ch.WillEnergizedAt
+	}
+	if ch.Attributes != nil {
+		merged := map[string]any{}
+		for k, v := range dev.Attributes {
+			merged[k] = v
+		}
+		for k, v := range ch.Attributes {
+			merged[k] = v
+		}
+		schemas, err := loadAttributeSchemas(dev.Project)
+		if err != nil {
+			return fail(syncRejected, err)
+		}
+		attrs, err := schemas.Validate(dev.Subject, merged)
+		if err != nil {
+			return fail(syncRejected, err)
+		}
+		changes["attributes"] = attrs
+	}
+	if len(changes) == 0 {
+		return fail(syncRejected, errors.New("no fields to update"))
+	}
+
+	if (ch.Energized != nil && *ch.Energized) || (ch.EnergizedToday != nil && *ch.EnergizedToday) {
+		unmet, err := offlineEnergizeBlockers(&dev, ch.Energized != nil && *ch.Energized)
+		if err != nil {
+			return fail(syncRejected, err)
+		}
+		if len(unmet) > 0 {
+			r.Unmet = unmet
+			return fail(syncRejected, errors.New("device cannot be energized"))
+		}
+	}
+
+	res := db.GetDB().Model(&models.Device{}).
+		Where("id = ? AND version = ?", dev.ID, dev.Version).
+		Updates(changes)
+	if res.Error != nil {
+		return fail(syncRejected, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		if err := db.GetDB().First(&dev, "id = ?", dev.ID).Error; err != nil {
+			return fail(syncDeleted, nil)
+		}
+		r.Device = &dev
+		return fail(syncConflict, nil)
+	}
+
+	if cat, err := loadSubjectCatalog(dev.Project); err == nil && cat.Energizable(dev.Subject) {
+		if ch.Energized != nil {
+			_ = setConnectedPolylinesEnergized([]string{dev.ID}, *ch.Energized)
+		}
+		if ch.EnergizedToday != nil {
+			_ = setConnectedPolylinesEnergizedToday([]string{dev.ID}, *ch.EnergizedToday)
+		}
+	}
+
+	if err := db.GetDB().First(&dev, "id = ?", dev.ID).Error; err == nil {
+		r.Device = &dev
+	}
+	r.Status = syncApplied
+	return r
+}
+
+// offlineEnergizeBlockers 离线送电前的检查：被锁住的支路不能送电；
+// energized 从 false 改成 true 时还要满足送电许可（离线修改不能 override）
+func offlineEnergizeBlockers(dev *models.Device, energize bool) ([]unmetPrerequisite, error) {
+	branches, err := loadLockedBranches(dev.Project)
+	if err != nil {
+		return nil, err
+	}
+	var unmet []unmetPrerequisite
+	for _, l := range branches.Locks(dev.ID) {
+		unmet = append(unmet, unmetPrerequisite{Code: "locked_out", Detail: fmt.Sprintf("lock %s held by %s on %s", l.LockID, l.Holder, l.DeviceID)})
+	}
+	if len(unmet) > 0 || !energize || dev.Energized {
+		return unmet, nil
+	}
+
+	gate := loadProjectSettings(dev.Project).EnergizationGate
+	if !gate.Enabled {
+		return nil, nil
+	}
+	cat, err := loadSubjectCatalog(dev.Project)
+	if err != nil {
+		return nil, err
+	}
+	if !gateApplies(cat, dev.Subject) {
+		return nil, nil
+	}
+	return checkEnergizeReadiness(dev, gate)
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"Cx_Mcdean_Backend/db"
+	"Cx_Mcdean_Backend/models"
+	"fmt"
+	"sort"
+)
+
+// topoEdge 配电图里的一条边：From 是上游、To 是下游。
+// Via 是形成这条边的 PolyLine id；由 panel 回路形成的边是 "circuit:<id>"。
+// 多电源设备的进线带 Role；Open 表示上游是没闭合的开关，这条边不供电；
+// Active 表示下游设备当前正由这一路供电（角色是当前电源且开关闭合）
+type topoEdge struct {
+	Via    string
+	From   string
+	To     string
+	Role   string
+	Open   bool
+	Active bool
+}
+
+// topology 一个项目的配电图。节点是除连线和建筑线以外的设备，
+// 边来自 PolyLine 的 from/to，以及 panel 回路的 downstream_id
+type topology struct {
+	Project string
+	Catalog *subjectCatalog
+	Nodes   map[string]*models.Device
+	Lines   map[string]*models.Device // PolyLine，按 id
+	Down    map[string][]topoEdge     // 上游 id -> 出边
+	Up      map[string][]topoEdge     // 下游 id -> 入边
+}
+
+// loadTopology 读出项目的设备、PolyLine 和回路，建好上下游邻接表。
+// 两端有一端不在节点里的 PolyLine 会被忽略
+func loadTopology(project string) (*topology, error) {
+	cat, err := loadSubjectCatalog(project)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []models.Device
+	if err := db.GetDB().Where("project = ?", project).Find(&devices).Error; err != nil {
+		return nil, err
+	}
+
+	t := &topology{
+		Project: project,
+		Catalog: cat,
+		Nodes:   map[string]*models.Device{},
+		Lines:   map[string]*models.Device{},
+		Down:    map[string][]topoEdge{},
+		Up:      map[string][]topoEdge{},
+	}
+	for i := range devices {
+		d := &devices[i]
+		switch cat.Category(d.Subject) {
+		case models.SubjectConnector:
+			t.Lines[d.ID] = d
+		case models.SubjectArchitectural:
+		default:
+			t.Nodes[d.ID] = d
+		}
+	}
+
+	lineIDs := make([]string, 0, len(t.Lines))
+	for id := range t.Lines {
+		lineIDs = append(lineIDs, id)
+	}
+	sort.Strings(lineIDs)
+	for _, id := range lineIDs {
+		l := t.Lines[id]
+		e := topoEdge{Via: l.ID, From: l.From, To: l.To, Role: l.SourceRole, Active: true}
+		if from := t.Nodes[l.From]; from != nil {
+			e.Open = !models.PositionConducts(from.Position)
+		}
+		if to := t.Nodes[l.To]; to != nil {
+			e.Active = models.SourceActive(l.SourceRole, to.ActiveSource) && !e.Open
+		}
+		t.addEdge(e)
+	}
+
+	var circuits []models.PanelCircuit
+	if err := db.GetDB().Where("project = ? AND downstream_id <> ''", project).Order("panel_id, number").Find(&circuits).Error; err != nil {
+		return nil, err
+	}
+	for _, ci := range circuits {
+		t.addEdge(topoEdge{Via: fmt.Sprintf("circuit:%d", ci.ID), From: ci.PanelID, To: ci.DownstreamID, Active: true})
+	}
+	return t, nil
+}
+
+// addEdge 两端都是节点才加，同一对上下游只保留一条
+func (t *topology) addEdge(e topoEdge) {
+	if e.From == "" || e.To == "" || e.From == e.To || t.Nodes[e.From] == nil || t.Nodes[e.To] == nil {
+		return
+	}
+	for _, x := range t.Down[e.From] {
+		if x.To == e.To {
+			return
+		}
+	}
+	t.Down[e.From] = append(t.Down[e.From], e)
+	t.Up[e.To] = append(t.Up[e.To], e)
+}
+
+// setPosition 改节点的开关位置（只改内存），同时更新它出边的 Open / Active
+func (t *topology) setPosition(id, position string) {
+	n := t.Nodes[id]
+	if n == nil {
+		return
+	}
+	n.Position = position
+	open := !models.PositionConducts(position)
+	for i, e := range t.Down[id] {
+		e.Open = open
+		e.Active = models.SourceActive(e.Role, t.Nodes[e.To].ActiveSource) && !open
+		t.Down[id][i] = e
+		for j, u := range t.Up[e.To] {
+			if u.Via == e.Via {
+				t.Up[e.To][j] = e
+			}
+		}
+	}
+}
+
+// Children 直接下游节点
+func (t *topology) Children(id string) []string {
+	out := make([]string, 0, len(t.Down[id]))
+	for _, e := range t.Down[id] {
+		out = append(out, e.To)
+	}
+	return out
+}
+
+// Parents 直接上游节点
+func (t *topology) Parents(id string) []string {
+	out := make([]string, 0, len(t.Up[id]))
+	for _, e := range t.Up[id] {
+		out = append(out, e.From)
+	}
+	return out
+}
+
+// Downstream 从 id 出发 BFS 能到的所有下游节点（不含自己），按距离排序
+func (t *topology) Downstream(id string) []string {
+	return t.walk(id, t.Children)
+}
+
+// FedDownstream 从 id 出发只经过闭合开关能到的下游节点，按距离排序
+func (t *topology) FedDownstream(id string) []string {
+	return t.walk(id, func(cur string) []string {
+		var out []string
+		for _, e := range t.Down[cur] {
+			if !e.Open {
+				out = append(out, e.To)
+			}
+		}
+		return out
+	})
+}
+
+// Upstream 从 id 出发 BFS 能到的所有上游节点（不含自己），按距离排序
+func (t *topology) Upstream(id string) []string {
+	return t.walk(id, t.Parents)
+}
+
+func (t *topology) walk(id string, next func(string) []string) []string {
+	seen := map[string]bool{id: true}
+	queue := []string{id}
+	var out []string
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, n := range next(cur) {
+			if seen[n] {
+				continue
+			}
+			seen[n] = true
+			out = append(out, n)
+			queue = append(queue, n)
+		}
+	}
+	return out
+}
+
+// Roots 没有上游但有下游的节点（电源侧），按 id 排序
+func (t *topology) Roots() []string {
+	var out []string
+	for id := range t.Down {
+		if len(t.Up[id]) == 0 {
+			out = append(out, id)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
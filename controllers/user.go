@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestUser 从 Entra JWT 中间件放进 context 的信息里取当前用户。
+// 请求没带令牌（匿名访问）时两个值都是空字符串
+func requestUser(c *gin.Context) (upn, oid string) {
+	return c.GetString("user_upn"), c.GetString("user_oid")
+}
+
+// requireUser 需要留下操作人的接口（签名、上锁、审批等）用它取当前用户。
+// 没有登录时已经写好 401，返回 ok=false
+func requireUser(c *gin.Context) (upn, oid string, ok bool) {
+	upn, oid = requestUser(c)
+	if upn == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return "", "", false
+	}
+	return upn, oid, true
+}
+
+// requestHasRole 当前用户的 roles 声明里是否有 allowed 中的任意一个
+func requestHasRole(c *gin.Context, allowed []string) bool {
+	for _, r := range c.GetStringSlice("user_roles") {
+		if slices.Contains(allowed, r) {
+			return true
+		}
+	}
+	return false
+}
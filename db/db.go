@@ -1,6 +1,7 @@
 package db
 
 import (
+	"encoding/json"
 	"log"
 	"time"
 
@@ -39,8 +40,29 @@ func Connect() (*gorm.DB, error) {
 
 	// 自动迁移
 	if err := db.AutoMigrate(
+		&models.Project{},
 		&models.Device{},
 		&models.DeviceFile{},
+		&models.ImportJob{},
+		&models.Page{},
+		&models.Subject{},
+		&models.AttributeDef{},
+		&models.PanelCircuit{},
+		&models.ChecklistTemplate{},
+		&models.Checklist{},
+		&models.ChecklistItem{},
+		&models.CxTransition{},
+		&models.EnergizationOverride{},
+		&models.Issue{},
+		&models.DeviceComment{},
+		&models.Lockout{},
+		&models.SwitchingPlan{},
+		&models.SwitchingStep{},
+		&models.SourceTransfer{},
+		&models.SwitchOperation{},
+		&models.ChangeEvent{},
+		&models.SchemaMigration{},
+		&models.QuarantinedGeometry{},
 	); err != nil {
 		return nil, err
 	}
@@ -57,10 +79,163 @@ func Connect() (*gorm.DB, error) {
 		return nil, err
 	}
 
+	if err := runOnce(db, "quarantine_legacy_geometry", quarantineLegacyGeometry); err != nil {
+		return nil, err
+	}
+	if err := migrateProjectReferences(db); err != nil {
+		return nil, err
+	}
+	if err := runOnce(db, "legacy_device_comments", migrateLegacyComments); err != nil {
+		return nil, err
+	}
+	if err := failAbandonedImportJobs(db); err != nil {
+		return nil, err
+	}
+
 	instance = db
 	return instance, nil
 }
 
+// failAbandonedImportJobs 导入任务跑在进程内，重启后没跑完的任务不会再继续，
+// 标成 failed，客户端就不会一直轮询。只处理一段时间没有进度的，不影响其他实例上正在跑的任务
+func failAbandonedImportJobs(db *gorm.DB) error {
+	now := time.Now()
+	res := db.Model(&models.ImportJob{}).
+		Where("status IN ? AND updated_at < ?", []string{models.ImportJobPending, models.ImportJobRunning}, now.Add(-models.ImportJobStaleAfter)).
+		Updates(map[string]any{"status": models.ImportJobFailed, "message": models.ImportJobAbandoned, "finished_at": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		log.Printf("marked %d abandoned import jobs as failed", res.RowsAffected)
+	}
+	return nil
+}
+
+// runOnce 执行一次性的数据迁移，成功后记到 schema_migrations，以后启动不再执行
+func runOnce(db *gorm.DB, name string, fn func(*gorm.DB) error) error {
+	var n int64
+	if err := db.Model(&models.SchemaMigration{}).Where("name = ?", name).Count(&n).Error; err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Create(&models.SchemaMigration{Name: name, AppliedAt: time.Now()}).Error
+	})
+}
+
+// quarantineLegacyGeometry 类型化几何之前写入的 polygon_points_px / short_segments_px
+// 可能解析不了。逐行试着解析，失败的原值存进 quarantined_geometries、设备上的列置空，并打日志。
+// 之后的写入都经过校验，所以只需要执行一次
+func quarantineLegacyGeometry(db *gorm.DB) error {
+	type geomRow struct {
+		ID       string
+		Polygon  *string
+		Segments *string
+	}
+	var list []geomRow
+	if err := db.Raw(`SELECT id, polygon_points_px::text AS polygon, short_segments_px::text AS segments FROM devices
+		WHERE polygon_points_px IS NOT NULL OR short_segments_px IS NOT NULL`).Scan(&list).Error; err != nil {
+		return err
+	}
+
+	var bad []models.QuarantinedGeometry
+	for _, r := range list {
+		if r.Polygon != nil {
+			var p models.PolylinePX
+			if err := json.Unmarshal([]byte(*r.Polygon), &p); err != nil {
+				bad = append(bad, models.QuarantinedGeometry{DeviceID: r.ID, Column: "polygon_points_px", Raw: *r.Polygon, Error: err.Error()})
+			}
+		}
+		if r.Segments != nil {
+			var s models.SegmentsPX
+			if err := json.Unmarshal([]byte(*r.Segments), &s); err != nil {
+				bad = append(bad, models.QuarantinedGeometry{DeviceID: r.ID, Column: "short_segments_px", Raw: *r.Segments, Error: err.Error()})
+			}
+		}
+	}
+	for _, q := range bad {
+		if err := db.Create(&q).Error; err != nil {
+			return err
+		}
+		if err := db.Exec(`UPDATE devices SET `+q.Column+` = NULL WHERE id = ?`, q.DeviceID).Error; err != nil {
+			return err
+		}
+		log.Printf("quarantined unparseable %s of device %s: %s", q.Column, q.DeviceID, q.Error)
+	}
+	return nil
+}
+
+// ProjectReferences 通过 project 外键引用 projects 的表，删除项目前要确认这些表里都没有数据了
+var ProjectReferences = []struct {
+	Model any
+	Table string
+}{
+	{&models.Device{}, "devices"},
+	{&models.DeviceFile{}, "device_files"},
+	{&models.Page{}, "pages"},
+	{&models.Subject{}, "subjects"},
+	{&models.AttributeDef{}, "attribute_defs"},
+	{&models.PanelCircuit{}, "panel_circuits"},
+	{&models.ChecklistTemplate{}, "checklist_templates"},
+	{&models.Checklist{}, "checklists"},
+	{&models.CxTransition{}, "cx_transitions"},
+	{&models.EnergizationOverride{}, "energization_overrides"},
+	{&models.Issue{}, "issues"},
+	{&models.DeviceComment{}, "device_comments"},
+	{&models.Lockout{}, "lockouts"},
+	{&models.SwitchingPlan{}, "switching_plans"},
+	{&models.SourceTransfer{}, "source_transfers"},
+	{&models.SwitchOperation{}, "switch_operations"},
+	{&models.ChangeEvent{}, "change_events"},
+}
+
+// migrateProjectReferences 把已有设备 / 文件里出现过的项目名补进 projects 表，
+// 再给 devices、device_files 以及各个按项目配置的表的 project 加外键。
+// 外键用 NOT VALID 创建：只约束之后的写入，不因为历史数据（比如空 project）迁移失败。
+func migrateProjectReferences(db *gorm.DB) error {
+	for _, table := range []string{"devices", "device_files"} {
+		if err := db.Exec(`INSERT INTO projects (name, display_name, timezone, status, created_at, updated_at)
+			SELECT DISTINCT project, project, 'UTC', 'active', now(), now() FROM ` + table + `
+			WHERE project <> '' ON CONFLICT (name) DO NOTHING`).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range ProjectReferences {
+		name := "fk_" + ref.Table + "_project"
+		if db.Migrator().HasConstraint(ref.Model, name) {
+			continue
+		}
+		if err := db.Exec(`ALTER TABLE ` + ref.Table + ` ADD CONSTRAINT ` + name + `
+			FOREIGN KEY (project) REFERENCES projects (name) ON UPDATE CASCADE NOT VALID`).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateLegacyComments 把设备原来的 comments 文本搬成评论线程的第一条（legacy），
+// 只在升级时执行一次（runOnce），之后设备接口不再接受非建筑类设备的 comments。
+// 墙、房间线、楼层线的 comments 存的是房间 / 楼层名，不是备注，跳过
+func migrateLegacyComments(db *gorm.DB) error {
+	return db.Exec(`INSERT INTO device_comments (project, device_id, body, legacy, created_at, updated_at)
+		SELECT d.project, d.id, btrim(d.comments), true, d.updated_at, d.updated_at FROM devices d
+		WHERE d.deleted_at IS NULL AND btrim(coalesce(d.comments, '')) <> ''
+		AND LOWER(d.subject) NOT IN ('wall', 'room line', 'level line')
+		AND NOT EXISTS (
+			SELECT 1 FROM subjects s
+			WHERE s.project = d.project AND s.category = 'architectural'
+			AND (LOWER(s.name) = LOWER(d.subject) OR LOWER(d.subject) IN (SELECT LOWER(a) FROM unnest(s.aliases) a))
+		)
+		AND NOT EXISTS (SELECT 1 FROM device_comments c WHERE c.device_id = d.id AND c.legacy)`).Error
+}
+
 func GetDB() *gorm.DB {
 	if instance == nil {
 		log.Fatal("DB not initialized. Call db.Connect() first.")
@@ -88,6 +88,16 @@ func NewEntraJWTMiddleware(cfg EntraJWTConfig) (gin.HandlerFunc, func(), error)
 		if upn, _ := claims["preferred_username"].(string); upn != "" {
 			c.Set("user_upn", upn)
 		}
+		// App roles（例如 Energization.Override），controller 里做细粒度授权
+		if raw, _ := claims["roles"].([]any); len(raw) > 0 {
+			roles := make([]string, 0, len(raw))
+			for _, r := range raw {
+				if s, ok := r.(string); ok {
+					roles = append(roles, s)
+				}
+			}
+			c.Set("user_roles", roles)
+		}
 
 		c.Next()
 	}
@@ -95,6 +105,18 @@ func NewEntraJWTMiddleware(cfg EntraJWTConfig) (gin.HandlerFunc, func(), error)
 	return mw, cleanup, nil
 }
 
+// Optional 带了 Authorization 时用 mw 校验（令牌无效照样 401），没带时按匿名放行。
+// 前端还没有全部接入登录；需要操作人的接口在 controller 里自己要求登录
+func Optional(mw gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		mw(c)
+	}
+}
+
 // 如果你要做 scope 授权（scp 里包含 "access_as_user"）
 func RequireScope(required string) gin.HandlerFunc {
 	return func(c *gin.Context) {
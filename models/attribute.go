@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// 属性值类型
+const (
+	AttrNumber  = "number"
+	AttrInteger = "integer"
+	AttrString  = "string"
+	AttrEnum    = "enum"
+	AttrBool    = "bool"
+)
+
+// AttributeDef 某个项目里某种 subject 的一个铭牌属性定义，
+// 例如 transformer 的 kva、panel board 的 amperage。
+// 设备的属性值存在 Device.Attributes（jsonb），写入时按这里的定义校验
+type AttributeDef struct {
+	ID       uint           `json:"id" gorm:"primaryKey"`
+	Project  string         `json:"project" gorm:"size:64;uniqueIndex:idx_attribute_defs_key"`
+	Subject  string         `json:"subject" gorm:"uniqueIndex:idx_attribute_defs_key"` // subject 目录里的标准名称
+	Key      string         `json:"key" gorm:"uniqueIndex:idx_attribute_defs_key"`     // 存进 attributes 的键，小写下划线
+	Label    string         `json:"label"`
+	Type     string         `json:"type"`           // number / integer / string / enum / bool
+	Unit     string         `json:"unit,omitempty"` // 例如 kVA、V、A
+	Required bool           `json:"required"`
+	Options  pq.StringArray `json:"options,omitempty" gorm:"type:text[]"` // enum 的可选值
+	Min      *float64       `json:"min,omitempty"`
+	Max      *float64       `json:"max,omitempty"`
+
+	SortOrder int `json:"sort_order"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
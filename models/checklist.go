@@ -0,0 +1,105 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// 检查项类型
+const (
+	ChecklistItemCheck  = "check"  // 只记录 pass / fail / na
+	ChecklistItemNumber = "number" // 记录测量值，可带上下限（例如绝缘电阻）
+	ChecklistItemText   = "text"   // 记录文字
+)
+
+// 检查项结果，空字符串表示还没做
+const (
+	ChecklistPass = "pass"
+	ChecklistFail = "fail"
+	ChecklistNA   = "na"
+)
+
+// 检查表状态
+const (
+	ChecklistOpen      = "open"
+	ChecklistCompleted = "completed" // 所有检查项都有结果
+)
+
+// ChecklistTemplate 某个项目里某种 subject 的检查表 / 测试程序模板，
+// 例如 panel board 的 L2 安装检查、transformer 的绝缘电阻测试
+type ChecklistTemplate struct {
+	ID      uint   `json:"id" gorm:"primaryKey"`
+	Project string `json:"project" gorm:"size:64;index"`
+	Subject string `json:"subject" gorm:"index"` // subject 目录里的标准名称
+	Name    string `json:"name"`
+	Level   string `json:"level,omitempty"` // 对应的调试等级，例如 L2
+	Active  bool   `json:"active" gorm:"default:true"`
+
+	Items datatypes.JSONType[[]ChecklistTemplateItem] `json:"items" gorm:"type:jsonb"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ChecklistTemplateItem 模板里的一个检查项
+type ChecklistTemplateItem struct {
+	Key      string   `json:"key"`
+	Text     string   `json:"text"`
+	Kind     string   `json:"kind"` // check / number / text
+	Unit     string   `json:"unit,omitempty"`
+	Min      *float64 `json:"min,omitempty"` // number 类型的合格范围
+	Max      *float64 `json:"max,omitempty"`
+	Required bool     `json:"required"`
+}
+
+// Checklist 按模板给某个设备生成的检查表实例
+type Checklist struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	Project    string `json:"project" gorm:"size:64;index"`
+	DeviceID   string `json:"device_id" gorm:"size:64;index"`
+	TemplateID uint   `json:"template_id" gorm:"index"`
+	Name       string `json:"name"`
+	Level      string `json:"level,omitempty"`
+	Status     string `json:"status" gorm:"index"` // open / completed
+
+	Total  int `json:"total"`  // 检查项数
+	Done   int `json:"done"`   // 已有结果的检查项数
+	Failed int `json:"failed"` // 结果为 fail 的检查项数
+
+	Percent float64 `json:"percent" gorm:"-"` // Done / Total，查询时计算
+
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedBy   string     `json:"created_by,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Items []ChecklistItem `json:"items,omitempty" gorm:"foreignKey:ChecklistID;constraint:OnDelete:CASCADE"`
+}
+
+// ChecklistItem 检查表实例里的一个检查项，定义从模板复制，结果由现场填写并签名
+type ChecklistItem struct {
+	ID          uint     `json:"id" gorm:"primaryKey"`
+	ChecklistID uint     `json:"checklist_id" gorm:"index"`
+	Seq         int      `json:"seq"`
+	Key         string   `json:"key"`
+	Text        string   `json:"text"`
+	Kind        string   `json:"kind"`
+	Unit        string   `json:"unit,omitempty"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	Required    bool     `json:"required"`
+
+	Result string `json:"result"` // "" / pass / fail / na
+	Value  string `json:"value,omitempty"`
+	Notes  string `json:"notes,omitempty"`
+
+	// 签名人来自 Entra 令牌（preferred_username / oid）
+	SignedBy    string     `json:"signed_by,omitempty"`
+	SignedByOID string     `json:"signed_by_oid,omitempty"`
+	SignedAt    *time.Time `json:"signed_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
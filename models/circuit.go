@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// PanelCircuit 配电盘（panel board）schedule 里的一路出线。
+// 多极断路器占用 Number、Number+2 ... 这几个位置（和盘面上奇偶两列的排法一致）
+type PanelCircuit struct {
+	ID      uint   `json:"id" gorm:"primaryKey"`
+	Project string `json:"project" gorm:"size:64;index"`
+	PanelID string `json:"panel_id" gorm:"size:64;uniqueIndex:idx_panel_circuits_number"` // 对应 Device.ID
+
+	Number      int     `json:"number" gorm:"uniqueIndex:idx_panel_circuits_number"` // 回路号，从 1 开始
+	Poles       int     `json:"poles"`                                               // 1 / 2 / 3
+	BreakerAmps float64 `json:"breaker_amps"`                                        // 断路器额定电流（A）
+	Description string  `json:"description"`                                         // 负载说明
+	LoadKVA     float64 `json:"load_kva"`
+
+	// 这一路供电的下游设备（另一个 panel、transformer 等），没有就为空
+	DownstreamID string `json:"downstream_id,omitempty" gorm:"size:64;index"`
+	Notes        string `json:"notes,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Slots 该回路占用的位置号
+func (c *PanelCircuit) Slots() []int {
+	poles := c.Poles
+	if poles < 1 {
+		poles = 1
+	}
+	slots := make([]int, poles)
+	for i := range slots {
+		slots[i] = c.Number + 2*i
+	}
+	return slots
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// DeviceComment 设备的评论线程，取代原来会被覆盖的 Device.Comments 文本
+type DeviceComment struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	Project  string `json:"project" gorm:"size:64;index"`
+	DeviceID string `json:"device_id" gorm:"size:64;index"`
+	Body     string `json:"body"`
+
+	// 作者来自 Entra 令牌的 preferred_username / oid；迁移过来的旧评论为空
+	Author    string `json:"author,omitempty" gorm:"index"`
+	AuthorOID string `json:"author_oid,omitempty"`
+
+	Mentions pq.StringArray `json:"mentions,omitempty" gorm:"type:text[]"`   // 被 @ 的用户（UPN）
+	FileIDs  pq.Int64Array  `json:"file_ids,omitempty" gorm:"type:bigint[]"` // 附件，对应 DeviceFile.ID
+	Legacy   bool           `json:"legacy,omitempty"`                        // 从 Device.Comments 迁移过来的
+
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+
+	CreatedAt time.Time      `json:"created_at" gorm:"index"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	Attachments []DeviceFile `json:"attachments,omitempty" gorm:"-"`
+}
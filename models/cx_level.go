@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// 调试（Cx）等级，按顺序推进
+const (
+	CxL1 = "L1" // 工厂见证
+	CxL2 = "L2" // 安装验证
+	CxL3 = "L3" // 预功能检查
+	CxL4 = "L4" // 功能测试
+	CxL5 = "L5" // 综合系统测试
+)
+
+// CxLevels 按顺序排列的等级，未开始的设备 cx_level 为空
+var CxLevels = []string{CxL1, CxL2, CxL3, CxL4, CxL5}
+
+// CxLevelNames 等级说明
+var CxLevelNames = map[string]string{
+	CxL1: "Factory witness",
+	CxL2: "Installation verified",
+	CxL3: "Pre-functional",
+	CxL4: "Functional",
+	CxL5: "Integrated systems test",
+}
+
+// CxRequirement 推进到某个等级前需要的证据
+type CxRequirement struct {
+	// 设备上至少要有这些 file_type 的文件各一份
+	FileTypes []string `json:"file_types,omitempty"`
+	// 该等级至少有一张检查表，且全部完成、没有 fail
+	RequireChecklist bool `json:"require_checklist"`
+}
+
+// CxTransition 设备调试等级的一次变更记录
+type CxTransition struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	Project   string `json:"project" gorm:"size:64;index"`
+	DeviceID  string `json:"device_id" gorm:"size:64;index"`
+	FromLevel string `json:"from_level"`
+	ToLevel   string `json:"to_level"`
+	Notes     string `json:"notes,omitempty"`
+
+	ChangedBy    string `json:"changed_by,omitempty"`
+	ChangedByOID string `json:"changed_by_oid,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
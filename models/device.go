@@ -10,29 +10,58 @@ import (
 
 type Device struct {
 	// 使用你的字符串 id 作为主键
-	ID              string         `json:"id" gorm:"primaryKey;size:64"`
-	Project         string         `json:"project" gorm:"index"`
-	FilePage        int            `json:"file_page" gorm:"index"`
-	Subject         string         `json:"subject" gorm:"index"`
-	RectPX          pq.Int64Array  `json:"rect_px" gorm:"type:integer[]"`
-	PolygonPointsPX datatypes.JSON `json:"polygon_points_px,omitempty" gorm:"type:jsonb"`
-	ShortSegmentsPX datatypes.JSON `json:"short_segments_px,omitempty" gorm:"type:jsonb"`
-	Text            string         `json:"text" gorm:"index"`
-	Comments        string         `json:"comments"`
-	Energized       bool           `json:"energized"`
-	EnergizedToday  bool           `json:"energized_today"`
-	From            string         `json:"from,omitempty"`
-	To              string         `json:"to,omitempty"`
+	ID              string        `json:"id" gorm:"primaryKey;size:64"`
+	Project         string        `json:"project" gorm:"index"`
+	FilePage        int           `json:"file_page" gorm:"index"`
+	Subject         string        `json:"subject" gorm:"index"`
+	RectPX          pq.Int64Array `json:"rect_px" gorm:"type:integer[]"`
+	PolygonPointsPX PolylinePX    `json:"polygon_points_px,omitempty" gorm:"type:jsonb"`
+	ShortSegmentsPX SegmentsPX    `json:"short_segments_px,omitempty" gorm:"type:jsonb"`
+	Text            string        `json:"text" gorm:"index"`
+	Comments        string        `json:"comments"`
+	Energized       bool          `json:"energized"`
+	EnergizedToday  bool          `json:"energized_today"`
+	From            string        `json:"from,omitempty"`
+	To              string        `json:"to,omitempty"`
 
 	ComputedFrom string `json:"computed_from,omitempty"`
 	ComputedTo   string `json:"computed_to,omitempty"`
 
+	// PolyLine：这条进线是下游设备的哪一路电源（normal / emergency / tie，空为唯一电源）
+	SourceRole string `json:"source_role,omitempty" gorm:"size:16"`
+	// ATS / 双端开关柜：当前由哪一路供电，只能通过 sources/transfer 接口修改
+	ActiveSource string `json:"active_source,omitempty" gorm:"size:16"`
+	// 开关设备的位置（closed / open / racked_out / tripped），只能通过 operate 接口修改
+	Position string `json:"position,omitempty" gorm:"size:16"`
+
 	WillEnergizedAt *time.Time `json:"will_energized_at,omitempty"`
 
+	// 调试等级（L1-L5，空为未开始），只能通过 cx transitions 接口修改
+	CxLevel   string     `json:"cx_level" gorm:"size:8;index"`
+	CxLevelAt *time.Time `json:"cx_level_at,omitempty"`
+
+	// 铭牌属性（kVA、电压、电流等），按项目的 AttributeDef 校验
+	Attributes datatypes.JSONMap `json:"attributes,omitempty" gorm:"type:jsonb"`
+
+	// 版本号，每次修改加一（见 BeforeUpdate），作为 ETag 给 If-Match 用
+	Version int64 `json:"version" gorm:"not null;default:1"`
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 
 	Files     []DeviceFile `json:"files" gorm:"foreignKey:DeviceID;references:ID"`
 	FileCount int64        `json:"file_count" gorm:"-"`
+
+	// 所有检查表合计的完成百分比，没有检查表时为空
+	ChecklistPct *float64 `json:"checklist_pct,omitempty" gorm:"-"`
+}
+
+// BeforeUpdate 按列修改设备（Update / Updates(map)）时把 version 加一。
+// 设备不会整行 Save；导入的 upsert 不走这个钩子，在 ON CONFLICT 里自己加
+func (d *Device) BeforeUpdate(tx *gorm.DB) error {
+	if _, ok := tx.Statement.Dest.(map[string]any); ok {
+		tx.Statement.SetColumn("version", gorm.Expr("version + 1"))
+	}
+	return nil
 }
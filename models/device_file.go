@@ -17,6 +17,9 @@ type DeviceFile struct {
 	FileSize int64  `json:"file_size"`
 	MimeType string `json:"mime_type"`
 
+	// 问题照片：对应 Issue.ID，普通设备文件为空
+	IssueID *uint `json:"issue_id,omitempty" gorm:"index"`
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
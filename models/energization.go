@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// 送电就绪检查项
+const (
+	GateTestReports       = "test_reports"       // 设备上有要求的文件（测试报告等）
+	GateUpstreamEnergized = "upstream_energized" // 至少一个上游设备已经送电
+	GateChecklists        = "checklists"         // 检查表全部完成且没有 fail
+	GateOpenIssues        = "open_issues"        // 没有未关闭的 blocking 问题
+)
+
+// EnergizationGate 项目的送电许可规则。启用后把设备 energized 从 false 改成 true 之前
+// 逐项检查，不满足时拒绝；已登录且有 OverrideRoles 角色的用户可以写明原因强制送电。
+// 设备导入不能 override：不满足的行按行报错
+type EnergizationGate struct {
+	Enabled bool `json:"enabled"`
+	// 启用的检查项，为空时全部检查
+	Checks []string `json:"checks,omitempty"`
+	// test_reports 检查要求的文件类型，为空时用 test_report
+	RequiredFileTypes []string `json:"required_file_types,omitempty"`
+	// 允许强制送电的 Entra app role，为空时用 Energization.Override
+	OverrideRoles []string `json:"override_roles,omitempty"`
+}
+
+// EnergizationOverride 强制送电的审计记录
+type EnergizationOverride struct {
+	ID       uint           `json:"id" gorm:"primaryKey"`
+	Project  string         `json:"project" gorm:"size:64;index"`
+	DeviceID string         `json:"device_id" gorm:"size:64;index"`
+	Reason   string         `json:"reason"`
+	Unmet    datatypes.JSON `json:"unmet" gorm:"type:jsonb"` // 当时没满足的条件
+
+	OverriddenBy    string `json:"overridden_by"`
+	OverriddenByOID string `json:"overridden_by_oid,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// 变更事件类型
+const (
+	EventDeviceCreated    = "device.created"
+	EventDeviceUpdated    = "device.updated"
+	EventDeviceDeleted    = "device.deleted"
+	EventDevicesImported  = "devices.imported"
+	EventDevicePropagated = "device.propagated" // 传播改掉的 PolyLine / Bus 状态
+	EventFileUploaded     = "file.uploaded"
+	EventFileDeleted      = "file.deleted"
+	EventCommentCreated   = "comment.created"
+	EventCommentUpdated   = "comment.updated"
+	EventCommentDeleted   = "comment.deleted"
+	EventLockoutApplied   = "lockout.applied" // 设备及下游显示为上锁，客户端重新拉取 lockouts/active
+	EventLockoutRemoved   = "lockout.removed"
+)
+
+// ChangeEvent 项目的变更事件，推送给订阅了该项目的客户端。
+// ID 自增，就是 SSE 的事件 id，断线重连时按 Last-Event-ID 补发
+type ChangeEvent struct {
+	ID       uint64         `json:"id" gorm:"primaryKey;index:idx_change_events_project_id,priority:2"`
+	Project  string         `json:"project" gorm:"size:64;index:idx_change_events_project_id,priority:1"`
+	Type     string         `json:"type" gorm:"size:32"`
+	EntityID string         `json:"entity_id,omitempty" gorm:"size:64"`
+	Data     datatypes.JSON `json:"data" gorm:"type:jsonb"`
+	Actor    string         `json:"actor,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// PointPX 图纸像素坐标 [x, y]，x 向右、y 向下
+type PointPX [2]float64
+
+// PolylinePX 对应 polygon_points_px：PolyLine 的折线点
+type PolylinePX []PointPX
+
+// SegmentsPX 对应 short_segments_px：按名字分组的短线段，例如 {"head": [...], "tail": [...]}
+type SegmentsPX map[string][]PointPX
+
+// PageBounds 页面像素尺寸，用来校验坐标是否越界；为 nil 时不校验
+type PageBounds struct {
+	Width  float64
+	Height float64
+}
+
+// GeometryError 几何校验失败，Field 是出错的 JSON 字段名
+type GeometryError struct {
+	Field  string
+	Reason string
+}
+
+func (e *GeometryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
+
+// QuarantinedGeometry 旧数据里解析不了的几何 jsonb。启动修复时原值搬到这里，
+// 设备上的列置空，避免一行坏数据让整个项目的查询都失败
+type QuarantinedGeometry struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	DeviceID  string    `json:"device_id" gorm:"index;size:64"`
+	Column    string    `json:"column"`
+	Raw       string    `json:"raw"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (PolylinePX) GormDataType() string { return "jsonb" }
+
+func (p PolylinePX) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return json.Marshal(p)
+}
+
+func (p *PolylinePX) Scan(value any) error {
+	return scanJSONB(value, p)
+}
+
+func (SegmentsPX) GormDataType() string { return "jsonb" }
+
+func (s SegmentsPX) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+	return json.Marshal(s)
+}
+
+func (s *SegmentsPX) Scan(value any) error {
+	return scanJSONB(value, s)
+}
+
+func scanJSONB(value any, dst any) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return errors.New("unsupported jsonb value")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// ValidateRect rect_px 必须是 [x1, y1, x2, y2] 且 x1 < x2、y1 < y2
+func ValidateRect(rect []int64, bounds *PageBounds) error {
+	if len(rect) != 4 {
+		return &GeometryError{Field: "rect_px", Reason: fmt.Sprintf("must have 4 elements [x1, y1, x2, y2], got %d", len(rect))}
+	}
+	if rect[0] >= rect[2] {
+		return &GeometryError{Field: "rect_px", Reason: "x1 must be less than x2"}
+	}
+	if rect[1] >= rect[3] {
+		return &GeometryError{Field: "rect_px", Reason: "y1 must be less than y2"}
+	}
+	if err := checkInBounds("rect_px", PointPX{float64(rect[0]), float64(rect[1])}, bounds); err != nil {
+		return err
+	}
+	return checkInBounds("rect_px", PointPX{float64(rect[2]), float64(rect[3])}, bounds)
+}
+
+// Validate 折线至少两个点
+func (p PolylinePX) Validate(bounds *PageBounds) error {
+	if len(p) < 2 {
+		return &GeometryError{Field: "polygon_points_px", Reason: "polyline needs at least 2 points"}
+	}
+	for _, pt := range p {
+		if err := checkInBounds("polygon_points_px", pt, bounds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate 每一段至少两个点
+func (s SegmentsPX) Validate(bounds *PageBounds) error {
+	for name, seg := range s {
+		field := "short_segments_px." + name
+		if len(seg) < 2 {
+			return &GeometryError{Field: field, Reason: "segment needs at least 2 points"}
+		}
+		for _, pt := range seg {
+			if err := checkInBounds(field, pt, bounds); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateGeometry 校验设备的全部几何字段；rect_px 必填，另外两个有就校验
+func (d *Device) ValidateGeometry(bounds *PageBounds) error {
+	if err := ValidateRect(d.RectPX, bounds); err != nil {
+		return err
+	}
+	if d.PolygonPointsPX != nil {
+		if err := d.PolygonPointsPX.Validate(bounds); err != nil {
+			return err
+		}
+	}
+	if d.ShortSegmentsPX != nil {
+		if err := d.ShortSegmentsPX.Validate(bounds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkInBounds(field string, pt PointPX, bounds *PageBounds) error {
+	if math.IsNaN(pt[0]) || math.IsNaN(pt[1]) || math.IsInf(pt[0], 0) || math.IsInf(pt[1], 0) {
+		return &GeometryError{Field: field, Reason: "coordinates must be finite numbers"}
+	}
+	if pt[0] < 0 || pt[1] < 0 {
+		return &GeometryError{Field: field, Reason: fmt.Sprintf("point (%g, %g) is outside the page", pt[0], pt[1])}
+	}
+	if bounds == nil {
+		return nil
+	}
+	if (bounds.Width > 0 && pt[0] > bounds.Width) || (bounds.Height > 0 && pt[1] > bounds.Height) {
+		return &GeometryError{Field: field, Reason: fmt.Sprintf("point (%g, %g) is outside the page (%gx%g)", pt[0], pt[1], bounds.Width, bounds.Height)}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// 导入任务状态
+const (
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobSucceeded = "succeeded"
+	ImportJobFailed    = "failed"
+)
+
+// 任务在进程内的 goroutine 里跑，每处理完一批都会更新 updated_at。
+// 超过这么久没更新的 pending / running 任务视为进程重启后被丢下，按失败处理
+const ImportJobStaleAfter = 10 * time.Minute
+
+// ImportJobAbandoned 被丢下的任务的 message
+const ImportJobAbandoned = "import was interrupted by a server restart, please run it again"
+
+// ImportJob 记录一次后台设备导入的进度和结果
+type ImportJob struct {
+	ID     uint   `json:"id" gorm:"primaryKey"`
+	Status string `json:"status" gorm:"index"` // pending / running / succeeded / failed
+	Phase  string `json:"phase"`               // upsert / propagate，前端显示当前阶段
+
+	Total      int `json:"total"`      // 请求里的行数
+	Processed  int `json:"processed"`  // 已处理的行数（成功 + 失败）
+	Succeeded  int `json:"succeeded"`  // 成功 upsert 的行数
+	Failed     int `json:"failed"`     // 失败的行数，详情见 errors
+	Propagated int `json:"propagated"` // 已完成状态传播的 panel 数
+
+	Errors  datatypes.JSON `json:"errors,omitempty" gorm:"type:jsonb"` // []ImportRowError
+	Message string         `json:"message,omitempty"`                  // 任务级错误（整个任务失败时）
+
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// ImportRowError 单行导入失败的原因，Row 是请求数组里的下标（从 0 开始）
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
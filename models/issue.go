@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 问题严重程度
+const (
+	IssueLow      = "low"
+	IssueMedium   = "medium"
+	IssueHigh     = "high"
+	IssueCritical = "critical"
+)
+
+// 问题状态
+const (
+	IssueOpen                 = "open"
+	IssueInProgress           = "in_progress"
+	IssueReadyForVerification = "ready_for_verification"
+	IssueClosed               = "closed"
+)
+
+// Issue 设备上的缺陷 / punch list 条目。照片用 DeviceFile（IssueID 指向这里）
+type Issue struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Project     string `json:"project" gorm:"size:64;index"`
+	DeviceID    string `json:"device_id" gorm:"size:64;index"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Severity    string `json:"severity" gorm:"index"` // low / medium / high / critical
+	Status      string `json:"status" gorm:"index"`   // open / in_progress / ready_for_verification / closed
+	// 未关闭时阻止送电（送电许可启用 open_issues 检查时）
+	Blocking bool `json:"blocking"`
+
+	Assignee string     `json:"assignee,omitempty" gorm:"index"`
+	DueDate  *time.Time `json:"due_date,omitempty"`
+
+	CreatedBy string     `json:"created_by,omitempty"`
+	ClosedBy  string     `json:"closed_by,omitempty"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	Photos []DeviceFile `json:"photos,omitempty" gorm:"foreignKey:IssueID"`
+}
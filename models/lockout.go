@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Lockout 一次上锁挂牌（LOTO）。RemovedAt 为空表示锁还在，
+// 这时该设备及其下游整条支路都不能送电
+type Lockout struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	Project  string `json:"project" gorm:"size:64;index;uniqueIndex:idx_lockouts_active_lock,where:removed_at IS NULL"`
+	DeviceID string `json:"device_id" gorm:"size:64;index"`
+	LockID   string `json:"lock_id" gorm:"uniqueIndex:idx_lockouts_active_lock,where:removed_at IS NULL"` // 锁上的编号，同一项目同时只能挂一次
+	Holder   string `json:"holder"`                                                                       // 持锁人
+	Permit   string `json:"permit,omitempty"`                                                             // 工作许可 / 停电许可编号
+	Notes    string `json:"notes,omitempty"`
+
+	AppliedAt    time.Time  `json:"applied_at" gorm:"index"`
+	AppliedBy    string     `json:"applied_by,omitempty"`
+	RemovedAt    *time.Time `json:"removed_at,omitempty" gorm:"index"`
+	RemovedBy    string     `json:"removed_by,omitempty"`
+	RemovalNotes string     `json:"removal_notes,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
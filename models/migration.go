@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// SchemaMigration 只需要执行一次的数据迁移，执行成功后记一行，下次启动跳过
+type SchemaMigration struct {
+	Name      string    `json:"name" gorm:"primaryKey;size:128"`
+	AppliedAt time.Time `json:"applied_at"`
+}
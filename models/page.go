@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Page 项目里的一张图纸。Device.FilePage 对应这里的 FilePage，
+// rect_px 等坐标都是在 WidthPX x HeightPX 的画布上
+type Page struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Project     string `json:"project" gorm:"size:64;uniqueIndex:idx_pages_project_page"`
+	FilePage    int    `json:"file_page" gorm:"uniqueIndex:idx_pages_project_page"`
+	SheetNumber string `json:"sheet_number"` // 图号，例如 E-601
+	Title       string `json:"title"`
+	Revision    string `json:"revision"`
+
+	WidthPX  int `json:"width_px"`
+	HeightPX int `json:"height_px"`
+	DPI      int `json:"dpi"`
+
+	// 源文件（PDF 或底图），通过 /pages/:page/source 上传下载
+	SourceName string `json:"source_name,omitempty"` // 原始文件名
+	SourcePath string `json:"-"`                     // 在服务器上的路径
+	SourceMime string `json:"source_mime,omitempty"`
+	SourceSize int64  `json:"source_size,omitempty"`
+
+	MarkupCount int64 `json:"markup_count" gorm:"-"` // 该页的标注数量，列表接口填充
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Bounds 页面尺寸已知时返回用于几何校验的边界，否则返回 nil
+func (p *Page) Bounds() *PageBounds {
+	if p == nil || p.WidthPX <= 0 || p.HeightPX <= 0 {
+		return nil
+	}
+	return &PageBounds{Width: float64(p.WidthPX), Height: float64(p.HeightPX)}
+}
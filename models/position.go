@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// 开关设备（Breaker、Bus Breaker 等 switching 类）的位置
+const (
+	PositionClosed    = "closed"
+	PositionOpen      = "open"
+	PositionRackedOut = "racked_out"
+	PositionTripped   = "tripped"
+)
+
+var Positions = []string{PositionClosed, PositionOpen, PositionRackedOut, PositionTripped}
+
+// PositionConducts 开关在这个位置时是否向下游供电。空表示没记录位置（或不是开关设备），按闭合处理
+func PositionConducts(position string) bool {
+	return position == "" || position == PositionClosed
+}
+
+// SwitchOperation 开关分合闸 / 摇出 / 跳闸的记录
+type SwitchOperation struct {
+	ID           uint   `json:"id" gorm:"primaryKey"`
+	Project      string `json:"project" gorm:"size:64;index"`
+	DeviceID     string `json:"device_id" gorm:"size:64;index"`
+	FromPosition string `json:"from_position"`
+	ToPosition   string `json:"to_position"`
+	Notes        string `json:"notes,omitempty"`
+
+	// 分闸后失电的下游设备
+	DeEnergized int `json:"de_energized"`
+
+	OperatedBy    string `json:"operated_by,omitempty"`
+	OperatedByOID string `json:"operated_by_oid,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// 项目状态
+const (
+	ProjectActive    = "active"
+	ProjectOnHold    = "on_hold"
+	ProjectCompleted = "completed"
+	ProjectArchived  = "archived"
+)
+
+// Project 项目。Device / DeviceFile 的 project 字段通过外键引用 Name
+type Project struct {
+	Name        string `json:"name" gorm:"primaryKey;size:64"` // 例如 "lsb"、"QTS"
+	DisplayName string `json:"display_name"`
+	SiteAddress string `json:"site_address"`
+	Timezone    string `json:"timezone"` // IANA 时区，例如 America/New_York
+	Owner       string `json:"owner"`
+	Status      string `json:"status" gorm:"index"` // active / on_hold / completed / archived
+
+	DrawingSet      string `json:"drawing_set"`      // 图纸集名称 / 编号
+	DrawingRevision string `json:"drawing_revision"` // 图纸集版本
+
+	Settings datatypes.JSONType[ProjectSettings] `json:"settings" gorm:"type:jsonb"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProjectSettings 每个项目可单独配置的规则，字段为空时用系统默认值
+type ProjectSettings struct {
+	// equipment 列表 / 导出包含的 subject
+	EquipmentSubjects []string `json:"equipment_subjects,omitempty"`
+	// 修改 energized / energized_today 时需要传播到 PolyLine / Bus 的 subject
+	PropagationSubjects []string `json:"propagation_subjects,omitempty"`
+	// 推进到各调试等级（L1-L5）需要的证据，没配的等级用默认要求
+	CxRequirements map[string]CxRequirement `json:"cx_requirements,omitempty"`
+	// 送电前的就绪检查，默认不启用
+	EnergizationGate *EnergizationGate `json:"energization_gate,omitempty"`
+}
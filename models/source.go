@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// 多电源设备（ATS 的常用 / 备用电源、双端开关柜的联络）的进线角色。
+// 写在进线 PolyLine 的 SourceRole 上，空表示唯一电源、始终带电
+const (
+	SourceNormal    = "normal"
+	SourceEmergency = "emergency"
+	SourceTie       = "tie" // 双端开关柜的联络开关，闭合时由另一段母线供电
+)
+
+var SourceRoles = []string{SourceNormal, SourceEmergency, SourceTie}
+
+// SourceActive 角色为 role 的进线在下游设备当前电源为 active 时是否在供电。
+// active 为空按 normal 处理（ATS 默认在常用电源，联络开关默认断开）
+func SourceActive(role, active string) bool {
+	if role == "" {
+		return true
+	}
+	if active == "" {
+		active = SourceNormal
+	}
+	return role == active
+}
+
+// SourceTransfer 多电源设备切换电源的记录
+type SourceTransfer struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	Project    string `json:"project" gorm:"size:64;index"`
+	DeviceID   string `json:"device_id" gorm:"size:64;index"`
+	FromSource string `json:"from_source"`
+	ToSource   string `json:"to_source"`
+	Notes      string `json:"notes,omitempty"`
+
+	ChangedBy    string `json:"changed_by,omitempty"`
+	ChangedByOID string `json:"changed_by_oid,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// subject 分类
+const (
+	SubjectEquipment     = "equipment"     // 出现在 equipment 列表 / 导出里的设备
+	SubjectSwitching     = "switching"     // 断路器等开关设备
+	SubjectConnector     = "connector"     // 连线（PolyLine）
+	SubjectBus           = "bus"           // 母线，状态由下游聚合
+	SubjectArchitectural = "architectural" // 墙、房间线、楼层线
+)
+
+// Subject 项目的 subject 目录条目。Device.Subject 按 Name 或 Aliases（不区分大小写）匹配
+type Subject struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Project     string         `json:"project" gorm:"size:64;uniqueIndex:idx_subjects_project_name"`
+	Name        string         `json:"name" gorm:"uniqueIndex:idx_subjects_project_name"` // 标准名称
+	Aliases     pq.StringArray `json:"aliases" gorm:"type:text[]"`                        // 其它写法，例如 "Generator"
+	Category    string         `json:"category" gorm:"index"`                             // equipment / switching / connector / bus / architectural
+	Energizable bool           `json:"energizable"`                                       // 修改送电状态时是否传播到 PolyLine / Bus
+
+	// 前端显示样式
+	Color     string `json:"color,omitempty"` // 例如 #1f77b4
+	Shape     string `json:"shape,omitempty"` // rect / line / polyline
+	SortOrder int    `json:"sort_order"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+// 操作票状态
+const (
+	SwitchingDraft      = "draft"
+	SwitchingApproved   = "approved"
+	SwitchingExecuting  = "executing"
+	SwitchingCompleted  = "completed"
+	SwitchingRolledBack = "rolled_back"
+)
+
+// 操作步骤类型
+const (
+	StepEnergize   = "energize"
+	StepDeEnergize = "de_energize"
+	StepVerify     = "verify" // 只确认设备状态，不改状态
+)
+
+// 步骤状态
+const (
+	StepPending    = "pending"
+	StepDone       = "done"
+	StepRolledBack = "rolled_back"
+)
+
+// SwitchingPlan 送电 / 停电操作票：按顺序执行的一组步骤，
+// 草稿 -> 审批 -> 逐步执行 -> 完成，执行中或完成后可以整体回退
+type SwitchingPlan struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Project     string `json:"project" gorm:"size:64;index"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Status      string `json:"status" gorm:"index"` // draft / approved / executing / completed / rolled_back
+
+	CreatedBy     string     `json:"created_by,omitempty"`
+	ApprovedBy    string     `json:"approved_by,omitempty"`
+	ApprovedAt    *time.Time `json:"approved_at,omitempty"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	RolledBackAt  *time.Time `json:"rolled_back_at,omitempty"`
+	RolledBackBy  string     `json:"rolled_back_by,omitempty"`
+	RollbackNotes string     `json:"rollback_notes,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Steps []SwitchingStep `json:"steps,omitempty" gorm:"foreignKey:PlanID;constraint:OnDelete:CASCADE"`
+}
+
+// SwitchingStep 操作票里的一步
+type SwitchingStep struct {
+	ID           uint   `json:"id" gorm:"primaryKey"`
+	PlanID       uint   `json:"plan_id" gorm:"index"`
+	Seq          int    `json:"seq"`
+	Action       string `json:"action"` // energize / de_energize / verify
+	DeviceID     string `json:"device_id" gorm:"size:64;index"`
+	Instructions string `json:"instructions,omitempty"`
+	// verify 步骤期望的 energized 状态
+	ExpectEnergized *bool `json:"expect_energized,omitempty"`
+
+	Status         string     `json:"status"` // pending / done / rolled_back
+	PerformedBy    string     `json:"performed_by,omitempty"`
+	PerformedByOID string     `json:"performed_by_oid,omitempty"`
+	PerformedAt    *time.Time `json:"performed_at,omitempty"`
+	Notes          string     `json:"notes,omitempty"`
+	// 执行前设备的 energized，回退时恢复
+	PrevEnergized *bool      `json:"prev_energized,omitempty"`
+	OverrideID    *uint      `json:"override_id,omitempty"` // 执行时用了强制送电
+	RolledBackAt  *time.Time `json:"rolled_back_at,omitempty"`
+
+	// 查询时补上，方便现场核对
+	DeviceText string `json:"device_text,omitempty" gorm:"-"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
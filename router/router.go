@@ -16,7 +16,8 @@ func Setup() *gin.Engine {
 	// 允许前端跨域（简单示例）
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID, If-Match")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -36,7 +37,7 @@ func Setup() *gin.Engine {
 
 	// 初始化 Entra JWT Middleware
 
-	_, _, err := middleware.NewEntraJWTMiddleware(middleware.EntraJWTConfig{
+	jwtMW, _, err := middleware.NewEntraJWTMiddleware(middleware.EntraJWTConfig{
 		TenantID: config.C.AzureTenantID,
 		Issuer:   config.C.AzureIssuer,
 		Audience: config.C.AzureAudience,
@@ -47,7 +48,8 @@ func Setup() *gin.Engine {
 	}
 
 	v1 := r.Group("/api/v1")
-	// v1.Use(jwtMW)
+	// 带令牌的请求校验并取出用户；全部接口强制登录时改成 v1.Use(jwtMW)
+	v1.Use(middleware.Optional(jwtMW))
 	{
 		dev := v1.Group("/devices")
 		{
@@ -63,15 +65,138 @@ func Setup() *gin.Engine {
 
 			dev.POST("/:id/files", controllers.UploadDeviceFile)
 			dev.GET("/:id/files", controllers.ListDeviceFiles)
+
+			// panel schedule 回路
+			dev.GET("/:id/circuits", controllers.ListPanelCircuits)
+			dev.POST("/:id/circuits", controllers.CreatePanelCircuit)
+			dev.POST("/:id/circuits/import", controllers.ImportPanelCircuits)
+			dev.PUT("/:id/circuits/:circuit", controllers.UpdatePanelCircuit)
+			dev.DELETE("/:id/circuits/:circuit", controllers.DeletePanelCircuit)
+			dev.GET("/:id/schedule", controllers.GetPanelSchedule)
+			// 反查给这个设备供电的回路
+			dev.GET("/:id/fed-by", controllers.GetDeviceFedBy)
+			// 停电影响范围（json / csv）
+			dev.GET("/:id/impact", controllers.GetDeviceImpact)
+			// 多电源设备（ATS、双端开关柜）的进线和电源切换
+			dev.GET("/:id/sources", controllers.GetDeviceSources)
+			dev.POST("/:id/sources/transfer", controllers.TransferDeviceSource)
+			// 开关分合闸
+			dev.GET("/:id/operations", controllers.ListSwitchOperations)
+			dev.POST("/:id/operate", controllers.OperateSwitch)
+
+			// 调试检查表
+			dev.GET("/:id/checklists", controllers.ListDeviceChecklists)
+			dev.POST("/:id/checklists", controllers.CreateDeviceChecklist)
+
+			// 调试等级（L1-L5）
+			dev.GET("/:id/cx", controllers.GetDeviceCx)
+			dev.POST("/:id/cx/transitions", controllers.CreateCxTransition)
+			// 送电前置条件检查
+			dev.GET("/:id/readiness", controllers.GetDeviceReadiness)
+
+			// 缺陷 / punch list
+			dev.GET("/:id/issues", controllers.ListDeviceIssues)
+			dev.POST("/:id/issues", controllers.CreateIssue)
+
+			// 评论线程
+			dev.GET("/:id/comments", controllers.ListDeviceComments)
+			dev.POST("/:id/comments", controllers.CreateDeviceComment)
+
+			// 上锁挂牌（LOTO）
+			dev.GET("/:id/lockouts", controllers.ListDeviceLockouts)
+			dev.POST("/:id/lockouts", controllers.ApplyLockout)
 		}
 
 		// ✅ 文件：按 fileId 下载 / 删除
 		v1.GET("/files/:id", controllers.DownloadDeviceFile)
 		v1.DELETE("/files/:id", controllers.DeleteDeviceFile)
+		// 检查表实例 / 检查项签名
+		v1.GET("/checklists/:id", controllers.GetChecklist)
+		v1.DELETE("/checklists/:id", controllers.DeleteChecklist)
+		v1.PUT("/checklists/:id/items/:item", controllers.UpdateChecklistItem)
+		// 问题
+		v1.GET("/issues/:id", controllers.GetIssue)
+		v1.PUT("/issues/:id", controllers.UpdateIssue)
+		v1.DELETE("/issues/:id", controllers.DeleteIssue)
+		v1.POST("/issues/:id/status", controllers.UpdateIssueStatus)
+		v1.POST("/issues/:id/files", controllers.UploadIssuePhoto)
+		// 评论
+		v1.PUT("/comments/:id", controllers.UpdateDeviceComment)
+		v1.DELETE("/comments/:id", controllers.DeleteDeviceComment)
+		// 解锁
+		v1.POST("/lockouts/:id/remove", controllers.RemoveLockout)
+		// 操作票：审批、逐步执行、回退
+		v1.GET("/switching-plans/:id", controllers.GetSwitchingPlan)
+		v1.PUT("/switching-plans/:id", controllers.UpdateSwitchingPlan)
+		v1.DELETE("/switching-plans/:id", controllers.DeleteSwitchingPlan)
+		v1.POST("/switching-plans/:id/approve", controllers.ApproveSwitchingPlan)
+		v1.POST("/switching-plans/:id/steps/:step/execute", controllers.ExecuteSwitchingStep)
+		v1.POST("/switching-plans/:id/rollback", controllers.RollbackSwitchingPlan)
+		// 导入任务进度
+		v1.GET("/import-jobs/:id", controllers.GetImportJob)
+		// 项目
+		v1.GET("/projects", controllers.ListProjects)
+		v1.POST("/projects", controllers.CreateProject)
+		v1.GET("/projects/:project", controllers.GetProject)
+		v1.PUT("/projects/:project", controllers.UpdateProject)
+		v1.DELETE("/projects/:project", controllers.DeleteProject)
 		// 新增：按项目名查找all设备
 		v1.GET("/projects/:project/devices", controllers.GetDevicesByProject)
 		// 新增：按项目名查找 specific equipments
 		v1.GET("/projects/:project/equipments", controllers.GetEquipmentsByProject)
+		// 导出 equipment 列表（csv / xlsx）
+		v1.GET("/projects/:project/equipments/export", controllers.ExportEquipmentsByProject)
+		// 连接负载 / 需用负载汇总（沿配电树向上）
+		v1.GET("/projects/:project/load-rollup", controllers.GetLoadRollup)
+		// 项目 punch list
+		v1.GET("/projects/:project/issues", controllers.ListProjectIssues)
+		v1.GET("/projects/:project/issues/summary", controllers.GetIssueSummary)
+		// 项目最新评论（可按 @提及 过滤）
+		v1.GET("/projects/:project/comments/recent", controllers.ListRecentComments)
+		// 项目当前有效的锁
+		v1.GET("/projects/:project/lockouts/active", controllers.ListActiveLockouts)
+		// 假设修改若干设备的状态，返回传播结果但不写库
+		v1.POST("/projects/:project/simulate", controllers.SimulateEnergization)
+		// 实时变更推送（SSE），断线后按 Last-Event-ID 补发
+		v1.GET("/projects/:project/events", controllers.StreamProjectEvents)
+		// 离线同步：拉取游标之后的变更，批量上传离线修改
+		v1.GET("/projects/:project/changes", controllers.GetProjectChanges)
+		v1.POST("/projects/:project/changes", controllers.UploadOfflineChanges)
+		// 项目的操作票
+		v1.GET("/projects/:project/switching-plans", controllers.ListSwitchingPlans)
+		v1.POST("/projects/:project/switching-plans", controllers.CreateSwitchingPlan)
+		// 各调试等级的 equipment 数量
+		v1.GET("/projects/:project/cx-progress", controllers.GetCxProgress)
+		// 项目的 subject 目录
+		v1.GET("/projects/:project/subjects", controllers.ListSubjects)
+		v1.POST("/projects/:project/subjects", controllers.CreateSubject)
+		v1.POST("/projects/:project/subjects/seed", controllers.SeedSubjects)
+		v1.PUT("/projects/:project/subjects/:id", controllers.UpdateSubject)
+		v1.DELETE("/projects/:project/subjects/:id", controllers.DeleteSubject)
+		// 设备铭牌属性定义
+		v1.GET("/projects/:project/attributes", controllers.ListAttributeDefs)
+		v1.POST("/projects/:project/attributes", controllers.CreateAttributeDef)
+		v1.PUT("/projects/:project/attributes/:id", controllers.UpdateAttributeDef)
+		v1.DELETE("/projects/:project/attributes/:id", controllers.DeleteAttributeDef)
+		// 检查表 / 测试程序模板
+		v1.GET("/projects/:project/checklist-templates", controllers.ListChecklistTemplates)
+		v1.POST("/projects/:project/checklist-templates", controllers.CreateChecklistTemplate)
+		v1.PUT("/projects/:project/checklist-templates/:id", controllers.UpdateChecklistTemplate)
+		v1.DELETE("/projects/:project/checklist-templates/:id", controllers.DeleteChecklistTemplate)
+		// 图纸页面
+		v1.GET("/projects/:project/pages", controllers.ListPages)
+		v1.POST("/projects/:project/pages", controllers.CreatePage)
+		v1.GET("/projects/:project/pages/:page", controllers.GetPage)
+		v1.PUT("/projects/:project/pages/:page", controllers.UpdatePage)
+		v1.DELETE("/projects/:project/pages/:page", controllers.DeletePage)
+		v1.POST("/projects/:project/pages/:page/source", controllers.UploadPageSource)
+		v1.GET("/projects/:project/pages/:page/source", controllers.DownloadPageSource)
+		// 按页渲染带状态颜色的标注图（打印送电图用）
+		v1.GET("/projects/:project/pages/:page/render.svg", controllers.RenderPageSVG)
+		v1.GET("/projects/:project/pages/:page/render.pdf", controllers.RenderPagePDF)
+		// 按页导出 / 导入 GeoJSON（像素坐标系）
+		v1.GET("/projects/:project/pages/:page/geojson", controllers.ExportPageGeoJSON)
+		v1.POST("/projects/:project/pages/:page/geojson", controllers.ImportPageGeoJSON)
 
 	}
 